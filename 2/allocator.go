@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// allocator issues counter values from reserved blocks. Allocating within the current block is a lock-free atomic
+// add, only replacing an exhausted block takes a lock.
+type allocator struct {
+	reserver  reserver
+	blockSize int
+	block     atomic.Pointer[block]
+	mu        sync.Mutex // serializes reservations
+}
+
+type block struct {
+	next atomic.Int64
+	end  int64
+}
+
+func newAllocator(reserver reserver, blockSize int) *allocator {
+	return &allocator{
+		reserver:  reserver,
+		blockSize: blockSize,
+	}
+}
+
+// allocate returns count unique values, consecutive unless spanning blocks.
+func (a *allocator) allocate(count int) ([]int, error) {
+	values := make([]int, 0, count)
+	for len(values) < count {
+		b := a.block.Load()
+		if b != nil {
+			missing := int64(count - len(values))
+			start := b.next.Add(missing) - missing
+			for value := start; value < min(start+missing, b.end); value++ {
+				values = append(values, int(value))
+			}
+			if start+missing <= b.end {
+				break
+			}
+		}
+		if err := a.refill(b); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// refill replaces the exhausted block, unless another allocation already did.
+func (a *allocator) refill(exhausted *block) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.block.Load() != exhausted {
+		return nil
+	}
+
+	start, err := a.reserver.reserve(a.blockSize)
+	if err != nil {
+		return err
+	}
+	b := &block{end: int64(start + a.blockSize)}
+	b.next.Store(int64(start))
+	a.block.Store(b)
+	return nil
+}
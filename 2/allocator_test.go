@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+// memReserver reserves blocks in memory, as if the high-water mark was persisted.
+type memReserver struct {
+	mu   sync.Mutex
+	mark int
+}
+
+func (r *memReserver) reserve(size int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := r.mark
+	r.mark += size
+	return start, nil
+}
+
+func TestAllocator_Unique(t *testing.T) {
+	a := newAllocator(&memReserver{}, 100)
+
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				values, err := a.allocate(j%7 + 1)
+				if err != nil {
+					t.Errorf("allocate failed: %v", err)
+					return
+				}
+				if len(values) != j%7+1 {
+					t.Errorf("allocate returned %d values, want %d", len(values), j%7+1)
+				}
+				mu.Lock()
+				for _, value := range values {
+					if seen[value] {
+						t.Errorf("value %d allocated twice", value)
+					}
+					seen[value] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestAllocator_Restart replaces the allocator as a restarted node would, over the high-water mark persisted by the
+// one before, and expects no value issued before the restart to be issued again.
+func TestAllocator_Restart(t *testing.T) {
+	self := func() nodeID { return "n0" }
+	kv := utils.NewMemKV()
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		reserver func() reserver // as created on each start of the node
+	}{
+		{
+			name:     "kv",
+			reserver: func() reserver { return &kvReserver{self: self, kv: kv} },
+		},
+		{
+			name:     "file",
+			reserver: func() reserver { return &fileReserver{self: self, dir: dir} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := newAllocator(tt.reserver(), 10)
+			values, err := before.allocate(25) // spanning blocks, the last one partly issued
+			if err != nil {
+				t.Fatal(err)
+			}
+			highWaterMark := values[len(values)-1]
+
+			after := newAllocator(tt.reserver(), 10)
+			values, err = after.allocate(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if values[0] <= highWaterMark {
+				t.Errorf("first value after the restart %d, want above %d issued before", values[0], highWaterMark)
+			}
+		})
+	}
+}
+
+func BenchmarkAllocator(b *testing.B) {
+	a := newAllocator(&memReserver{}, blockSize)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := a.allocate(1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAllocator_Batch100(b *testing.B) {
+	a := newAllocator(&memReserver{}, blockSize)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := a.allocate(100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// The replaced goroutine handing out a counter through an unbuffered channel, for comparison.
+func BenchmarkChannelCounter(b *testing.B) {
+	intStream := make(chan int)
+	go func() {
+		i := 0
+		for {
+			intStream <- i
+			i += 1
+		}
+	}()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			<-intStream
+		}
+	})
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
+	"slices"
 	"strconv"
 
 	utils "github.com/tobiajo/gossip-gloomers/utils"
@@ -11,39 +15,149 @@ import (
 
 type server struct {
 	self      func() nodeID
-	intStream chan int
+	allocator *allocator
+	snowflake *snowflake
+	n         *maelstrom.Node
+	sequencer *sequencer
 }
 
 type nodeID = string
 
+// idScheme is the format of generated IDs.
+type idScheme string
+
+const (
+	counterScheme   idScheme = "counter"   // node ID and a per-node counter, e.g. "n1-m42"
+	snowflakeScheme idScheme = "snowflake" // time sortable int64
+	uuidV7Scheme    idScheme = "uuidv7"    // time sortable UUID
+	ulidScheme      idScheme = "ulid"      // time sortable ULID
+)
+
+// reservationStore is where the counter scheme persists its high-water marks.
+type reservationStore string
+
+const (
+	linKVStore reservationStore = "lin-kv"
+	fileStore  reservationStore = "file"
+)
+
+const (
+	scheme          = counterScheme
+	clockRegression = waitOnRegression
+	reservation     = linKVStore
+	blockSize       = 10000     // counter values reserved at a time
+	maxBatch        = blockSize // IDs per generate_batch
+)
+
 // Challenge #2: Unique ID Generation
 // https://fly.io/dist-sys/2
 func main() {
 	n := maelstrom.NewNode()
-	intStream := make(chan int)
 	s := server{
 		self:      func() nodeID { return n.ID() }, // nodeID avaliable after init
-		intStream: intStream,
+		allocator: newAllocator(newReserver(n), blockSize),
+		snowflake: newSnowflake(func() int { return slices.Index(n.NodeIDs(), n.ID()) }, clockRegression),
+		n:         n,
+		sequencer: &sequencer{
+			self: func() nodeID { return n.ID() },
+			kv:   maelstrom.NewLinKV(n),
+		},
 	}
 
-	go func() {
-		i := 0
-		for {
-			intStream <- i
-			i += 1
-		}
-	}()
-
 	utils.RegisterHandler(n, "generate", s.generateHandler)
+	utils.RegisterHandler(n, "generate_batch", s.generateBatchHandler)
+	utils.RegisterHandler(n, "generate_seq", s.generateSeqHandler)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+func newReserver(n *maelstrom.Node) reserver {
+	self := func() nodeID { return n.ID() }
+	switch reservation {
+	case fileStore:
+		return &fileReserver{self: self, dir: os.TempDir()}
+	default:
+		return &kvReserver{self: self, kv: maelstrom.NewLinKV(n)}
+	}
+}
+
 func (s *server) generateHandler(req Generate) (GenerateOk, error) {
+	ids, err := s.generate(1)
+	if err != nil {
+		return *new(GenerateOk), err
+	}
+
 	res := GenerateOk{
-		Id: s.self() + "-m" + strconv.Itoa(<-s.intStream),
+		Id: ids[0],
+	}
+	return res, nil
+}
+
+func (s *server) generateBatchHandler(req GenerateBatch) (GenerateBatchOk, error) {
+	if req.Count < 1 || req.Count > maxBatch {
+		return *new(GenerateBatchOk), maelstrom.NewRPCError(maelstrom.MalformedRequest, "count must be from 1 to "+strconv.Itoa(maxBatch))
+	}
+	ids, err := s.generate(req.Count)
+	if err != nil {
+		return *new(GenerateBatchOk), err
+	}
+
+	res := GenerateBatchOk{
+		Ids: ids,
+	}
+	return res, nil
+}
+
+func (s *server) generate(count int) ([]any, error) {
+	ids := make([]any, 0, count)
+	if scheme == counterScheme {
+		values, err := s.allocator.allocate(count) // from reserved blocks, so a restarted node starts beyond any issued value
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			ids = append(ids, s.self()+"-m"+strconv.Itoa(value))
+		}
+		return ids, nil
+	}
+
+	for len(ids) < count {
+		id := s.snowflake.next()
+		switch scheme {
+		case snowflakeScheme:
+			ids = append(ids, id.int64())
+		case uuidV7Scheme:
+			ids = append(ids, id.uuidV7())
+		case ulidScheme:
+			ids = append(ids, id.ulid())
+		}
+	}
+	return ids, nil
+}
+
+func (s *server) generateSeqHandler(req GenerateSeq) (GenerateSeqOk, error) {
+	seq, err := s.sequencer.generate()
+	var held *leaseHeldError
+	if errors.As(err, &held) && !req.Forwarded {
+		ctx, cancel := context.WithTimeout(context.Background(), seqForwardTimeout)
+		defer cancel()
+		res, err := utils.SendWithContext[GenerateSeq, GenerateSeqOk](ctx, s.n, "generate_seq", held.holder, GenerateSeq{Forwarded: true})
+		if err != nil {
+			return *new(GenerateSeqOk), maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error()) // lease holder unreachable
+		}
+		return res, nil
+	}
+	if held != nil {
+		return *new(GenerateSeqOk), maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error()) // moved meanwhile
+	}
+	if err != nil {
+		return *new(GenerateSeqOk), err
+	}
+
+	res := GenerateSeqOk{
+		Seq: seq,
 	}
 	return res, nil
 }
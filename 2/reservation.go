@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// reserver hands out blocks of counter values, disjoint across restarts of the node, by persisting a high-water mark
+// before any value of a block is issued.
+type reserver interface {
+	reserve(size int) (int, error) // start of the block [start, start+size)
+}
+
+func highWaterMarkKey(node nodeID) string {
+	return "highWaterMark:" + node
+}
+
+// kvReserver persists the high-water mark of each node in lin-kv.
+type kvReserver struct {
+	self func() nodeID
+	kv   utils.KV
+}
+
+func (r *kvReserver) reserve(size int) (int, error) {
+	key := highWaterMarkKey(r.self())
+	for {
+		mark, err := utils.ReadOrElse(r.kv, key, 0)
+		if err != nil {
+			return *new(int), err
+		}
+		err = r.kv.CompareAndSwap(context.Background(), key, mark, mark+size, true)
+		if err == nil {
+			return mark, nil
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return *new(int), err
+		}
+	}
+}
+
+// fileReserver persists the high-water mark of each node in a local file, replaced atomically on reservation.
+type fileReserver struct {
+	self func() nodeID
+	dir  string
+}
+
+func (r *fileReserver) reserve(size int) (int, error) {
+	path := filepath.Join(r.dir, "gossip-gloomers-"+r.self()+".hwm")
+	mark := 0
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return *new(int), err
+	}
+	if err == nil {
+		if mark, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return *new(int), err
+		}
+	}
+
+	tmp, err := os.CreateTemp(r.dir, filepath.Base(path)+".*")
+	if err != nil {
+		return *new(int), err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.Itoa(mark + size)); err != nil {
+		tmp.Close()
+		return *new(int), err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return *new(int), err
+	}
+	if err := tmp.Close(); err != nil {
+		return *new(int), err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return *new(int), err
+	}
+	return mark, nil
+}
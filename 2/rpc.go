@@ -3,5 +3,21 @@ package main
 type Generate struct{}
 
 type GenerateOk struct {
-	Id string `json:"id"`
+	Id any `json:"id"` // string, or number for the snowflake scheme
+}
+
+type GenerateBatch struct {
+	Count int `json:"count"`
+}
+
+type GenerateBatchOk struct {
+	Ids []any `json:"ids"`
+}
+
+type GenerateSeq struct {
+	Forwarded bool `json:"forwarded,omitempty"` // from a node not holding the lease
+}
+
+type GenerateSeqOk struct {
+	Seq int `json:"seq"`
 }
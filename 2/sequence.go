@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	seqRangeSize      = 1000
+	seqLeaseDuration  = 2 * time.Second
+	seqLeaseMargin    = time.Second / 2 // the holder stops issuing this long before expiry, covers clock skew
+	seqForwardTimeout = time.Second / 2
+)
+
+// seqLease grants its holder the sequence numbers below End until Expires. Only one node holds the lease at a time,
+// ranges are handed out in global order and a lease not renewed, e.g. of a crashed node, is reclaimed once expired.
+// The unused rest of a reclaimed range is skipped, so sequence numbers are strictly increasing but may have gaps.
+type seqLease struct {
+	Holder  nodeID `json:"holder"`
+	End     int    `json:"end"`
+	Expires int64  `json:"expires"` // unix millis
+}
+
+type sequencer struct {
+	self  func() nodeID
+	kv    utils.KV
+	mu    sync.Mutex
+	lease seqLease // as last written by this node
+	next  int
+}
+
+// leaseHeldError is returned if another node holds an unexpired lease.
+type leaseHeldError struct {
+	holder nodeID
+}
+
+func (e *leaseHeldError) Error() string {
+	return "sequence lease held by " + e.holder
+}
+
+func (s *sequencer) generate() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	holding := s.lease.Holder == s.self() && now < s.lease.Expires-seqLeaseMargin.Milliseconds()
+	if !holding || s.next >= s.lease.End || now > s.lease.Expires-seqLeaseDuration.Milliseconds()/2 {
+		if err := s.renew(now); err != nil {
+			return *new(int), err
+		}
+	}
+	seq := s.next
+	s.next++
+	return seq, nil
+}
+
+// renew extends the lease held by this node, or acquires an expired one, caller must hold s.mu.
+func (s *sequencer) renew(now int64) error {
+	current, err := utils.ReadOrElse(s.kv, "seqLease", seqLease{})
+	if err != nil {
+		return err
+	}
+	mine := current == s.lease && current.Holder == s.self()
+	if !mine && current.Expires > now {
+		if current.Holder == s.self() { // of before a restart, or written by a CAS whose reply was lost
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "sequence lease of this node unknown until expired")
+		}
+		return &leaseHeldError{current.Holder}
+	}
+
+	renewed := seqLease{
+		Holder:  s.self(),
+		End:     current.End,
+		Expires: now + seqLeaseDuration.Milliseconds(),
+	}
+	if !mine {
+		s.next = current.End // skips the rest of a reclaimed range
+	}
+	if s.next >= renewed.End {
+		renewed.End = s.next + seqRangeSize
+	}
+	err = s.kv.CompareAndSwap(context.Background(), "seqLease", current, renewed, true)
+	if err != nil {
+		if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "lost sequence lease race")
+		}
+		return err
+	}
+	s.lease = renewed
+	return nil
+}
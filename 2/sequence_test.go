@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestSequencer_Restart(t *testing.T) {
+	kv := utils.NewMemKV()
+	s := &sequencer{self: func() nodeID { return "n0" }, kv: kv}
+	if _, err := s.generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &sequencer{self: func() nodeID { return "n1" }, kv: kv}
+	_, err := other.generate()
+	var held *leaseHeldError
+	if !errors.As(err, &held) || held.holder != "n0" {
+		t.Errorf("generate at another node = %v, want lease held by n0", err)
+	}
+
+	restarted := &sequencer{self: func() nodeID { return "n0" }, kv: kv}
+	_, err = restarted.generate()
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("generate after restart = %v, want temporarily unavailable rather than forwarding to itself", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/google/uuid"
+)
+
+const (
+	epoch        = 1700000000000 // unix millis, 41 bits of timestamp last until 2093
+	rankBits     = 10
+	sequenceBits = 12
+	maxSequence  = 1<<sequenceBits - 1
+)
+
+// regressionPolicy decides what happens when the wall clock moves backwards, e.g. after an NTP adjustment.
+type regressionPolicy string
+
+const (
+	waitOnRegression   regressionPolicy = "wait"   // block until the clock passes the last timestamp
+	borrowOnRegression regressionPolicy = "borrow" // keep issuing from the last timestamp as a logical clock
+)
+
+// snowflake issues (timestamp, rank, sequence) triples that are unique as long as node ranks are, and sortable by
+// time within the precision of the nodes' clocks.
+// https://en.wikipedia.org/wiki/Snowflake_ID
+type snowflake struct {
+	rank       func() int
+	now        func() int64 // unix millis
+	regression regressionPolicy
+	mu         sync.Mutex
+	lastTs     int64
+	sequence   int
+}
+
+type snowflakeID struct {
+	ts       int64
+	rank     int
+	sequence int
+}
+
+func newSnowflake(rank func() int, regression regressionPolicy) *snowflake {
+	return &snowflake{
+		rank:       rank,
+		now:        func() int64 { return time.Now().UnixMilli() },
+		regression: regression,
+	}
+}
+
+func (g *snowflake) next() snowflakeID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ts := g.now()
+	if ts < g.lastTs {
+		ts = g.await(g.lastTs)
+	}
+	if ts == g.lastTs {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 { // exhausted for this millisecond
+			ts = g.await(g.lastTs + 1)
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTs = ts
+
+	return snowflakeID{ts, g.rank(), g.sequence}
+}
+
+// await returns a timestamp of at least ts, waiting for the clock or borrowing from it as per the policy.
+func (g *snowflake) await(ts int64) int64 {
+	if g.regression == borrowOnRegression {
+		return ts
+	}
+	now := g.now()
+	for now < ts {
+		time.Sleep(time.Duration(ts-now) * time.Millisecond)
+		now = g.now()
+	}
+	return now
+}
+
+// int64 packs 41 bits of milliseconds since the epoch, 10 bits of rank and 12 bits of sequence.
+func (id snowflakeID) int64() int64 {
+	return (id.ts-epoch)<<(rankBits+sequenceBits) | int64(id.rank)<<sequenceBits | int64(id.sequence)
+}
+
+// uuidV7 puts the sequence in rand_a and the rank in the high bits of rand_b, the rest is random.
+// https://www.rfc-editor.org/rfc/rfc9562#name-uuid-version-7
+func (id snowflakeID) uuidV7() string {
+	var u uuid.UUID
+	binary.BigEndian.PutUint64(u[8:], randomUint64())
+	binary.BigEndian.PutUint64(u[0:], uint64(id.ts)<<16|0x7<<12|uint64(id.sequence))
+	u[8] = 0x80 | byte(id.rank>>4)&0x3f // variant 10, then 6 high bits of the rank
+	u[9] = byte(id.rank&0xf)<<4 | u[9]&0x0f
+	return u.String()
+}
+
+// ulid puts the rank and the sequence in the high bits of the randomness, the rest is random.
+// https://github.com/ulid/spec
+func (id snowflakeID) ulid() string {
+	hi := uint64(id.ts)<<16 | uint64(id.rank)<<6 | uint64(id.sequence)>>6 // 48 bits time, 10 bits rank, 6 bits sequence
+	lo := uint64(id.sequence&0x3f)<<58 | randomUint64()>>6                // 6 bits sequence, 58 bits random
+	return encodeCrockford(hi, lo)
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeCrockford encodes 128 bits as 26 characters of Crockford's base32, the first carrying the top 3 bits.
+func encodeCrockford(hi uint64, lo uint64) string {
+	var b strings.Builder
+	for i := 25; i >= 0; i-- {
+		shift := uint(i * 5)
+		var digit uint64
+		switch {
+		case shift >= 64:
+			digit = hi >> (shift - 64)
+		case shift > 59:
+			digit = hi<<(64-shift) | lo>>shift
+		default:
+			digit = lo >> shift
+		}
+		b.WriteByte(crockford[digit&0x1f])
+	}
+	return b.String()
+}
+
+func randomUint64() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
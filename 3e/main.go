@@ -14,7 +14,7 @@ type server struct {
 	n                   *maelstrom.Node
 	deliveredSelf       mapset.Set[message]
 	unconfirmedDelivery mapset.Set[delivery]
-	pendingDelivery     mapset.Set[delivery]
+	pendingDelivery     *utils.Batcher[message]
 }
 
 type nodeID = string
@@ -25,6 +25,12 @@ type delivery struct {
 	message message
 }
 
+const (
+	maxBatch    = 100
+	minInterval = time.Second / 20
+	latencySLO  = time.Second // only change from 3d
+)
+
 // Challenge #3e: Efficient Broadcast, Part II
 // https://fly.io/dist-sys/3e
 func main() {
@@ -33,32 +39,22 @@ func main() {
 		n:                   n,
 		deliveredSelf:       mapset.NewSet[message](),
 		unconfirmedDelivery: mapset.NewSet[delivery](),
-		pendingDelivery:     mapset.NewSet[delivery](),
 	}
+	s.pendingDelivery = utils.NewBatcher(utils.BatcherConfig{
+		MaxBatch:    maxBatch,
+		MinInterval: minInterval,
+		LatencySLO:  latencySLO,
+	}, func(dest nodeID, messages []message) {
+		err := utils.SendAsync(n, "deliver", dest, Deliver{messages})
+		if err != nil {
+			log.Printf("error async deliver to %s: %v", dest, err)
+		}
+	})
 
 	go func() {
 		for range time.Tick(time.Second) {
 			for _, delivery := range s.unconfirmedDelivery.ToSlice() {
-				s.pendingDelivery.Add(delivery) // batches delivery
-			}
-		}
-	}()
-
-	go func() {
-		for range time.Tick(time.Second) { // only change from 3d
-			messages := make(map[nodeID][]message)
-			for _, delivery := range s.pendingDelivery.ToSlice() {
-				if _, present := messages[delivery.dest]; !present {
-					messages[delivery.dest] = []message{}
-				}
-				messages[delivery.dest] = append(messages[delivery.dest], delivery.message)
-				s.pendingDelivery.Remove(delivery)
-			}
-			for dest, messages := range messages {
-				err := utils.SendAsync(n, "deliver", dest, Deliver{messages})
-				if err != nil {
-					log.Printf("error async deliver to %s: %v", dest, err)
-				}
+				s.pendingDelivery.Add(delivery.dest, delivery.message) // batches delivery
 			}
 		}
 	}()
@@ -81,7 +77,7 @@ func (s *server) broadcastHandler(req Broadcast) (BroadcastOk, error) {
 	for _, dest := range s.n.NodeIDs() {
 		delivery := delivery{dest, req.Message}
 		s.unconfirmedDelivery.Add(delivery)
-		s.pendingDelivery.Add(delivery)
+		s.pendingDelivery.Add(delivery.dest, delivery.message)
 	}
 
 	res := BroadcastOk{}
@@ -1,10 +1,14 @@
 package main
 
+import (
+	. "github.com/tobiajo/gossip-gloomers/common"
+)
+
 type Deliver struct {
-	Messages []int `json:"messages"`
+	Messages IntRanges `json:"messages"`
 }
 
 type DeliverOk struct {
-	Src      string `json:"src"`
-	Messages []int  `json:"message"`
+	Src      string    `json:"src"`
+	Messages IntRanges `json:"message"`
 }
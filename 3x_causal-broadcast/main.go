@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type server struct {
+	n                   *maelstrom.Node
+	mu                  sync.Mutex
+	clock               vectorClock // messages delivered per origin
+	deliveredSelf       []message   // in causal order
+	buffered            map[messageID]Deliver
+	outbox              map[messageID]Deliver // until delivered to every peer
+	unacked             map[messageID]int     // peers yet to confirm delivery of the outbox messages
+	unconfirmedDelivery mapset.Set[delivery]
+}
+
+type nodeID = string
+type message = int
+type vectorClock = map[nodeID]int
+
+type messageID struct {
+	origin nodeID
+	seq    int
+}
+
+type delivery struct {
+	dest nodeID
+	id   messageID
+}
+
+// Causal broadcast, fault tolerant delivery as in 3c where each message carries the vector clock of its origin at
+// broadcast time. Messages are buffered until all causal predecessors are delivered.
+// https://en.wikipedia.org/wiki/Causal_consistency
+func main() {
+	n := maelstrom.NewNode()
+	s := server{
+		n:                   n,
+		clock:               vectorClock{},
+		deliveredSelf:       []message{},
+		buffered:            make(map[messageID]Deliver),
+		outbox:              make(map[messageID]Deliver),
+		unacked:             make(map[messageID]int),
+		unconfirmedDelivery: mapset.NewSet[delivery](),
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			for _, delivery := range s.unconfirmedDelivery.ToSlice() {
+				s.mu.Lock()
+				req, ok := s.outbox[delivery.id]
+				s.mu.Unlock()
+				if !ok {
+					continue // confirmed meanwhile
+				}
+				err := utils.SendAsync(s.n, "deliver", delivery.dest, req)
+				if err != nil {
+					log.Printf("error async deliver to %s: %v", delivery.dest, err)
+				}
+			}
+		}
+	}()
+
+	// external
+	utils.RegisterHandler(n, "broadcast", s.broadcastHandler)
+	utils.RegisterHandler(n, "read", s.readHandler)
+	utils.RegisterHandler(n, "topology", s.topologyHandler)
+
+	// internal
+	utils.RegisterHandler(n, "deliver", s.deliverHandler)
+	utils.RegisterAsyncHandler(n, "deliver_ok", s.deliverOkHandler)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *server) broadcastHandler(req Broadcast) (BroadcastOk, error) {
+	s.mu.Lock()
+	s.clock[s.n.ID()]++ // delivered to self, so the clock also covers this message
+	deliverReq := Deliver{
+		Origin:  s.n.ID(),
+		Clock:   maps.Clone(s.clock),
+		Message: req.Message,
+	}
+	id := deliverReq.ID()
+	s.deliveredSelf = append(s.deliveredSelf, req.Message)
+	if peers := len(s.n.NodeIDs()) - 1; peers > 0 {
+		s.outbox[id] = deliverReq
+		s.unacked[id] = peers
+	}
+	s.mu.Unlock()
+
+	for _, dest := range s.n.NodeIDs() {
+		if dest == s.n.ID() {
+			continue
+		}
+		s.unconfirmedDelivery.Add(delivery{dest, id})
+		err := utils.SendAsync(s.n, "deliver", dest, deliverReq)
+		if err != nil {
+			return *new(BroadcastOk), err
+		}
+	}
+
+	res := BroadcastOk{}
+	return res, nil
+}
+
+func (s *server) readHandler(req Read) (ReadOk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := ReadOk{
+		Messages: append([]message{}, s.deliveredSelf...),
+	}
+	return res, nil
+}
+
+func (s *server) topologyHandler(req Topology) (TopologyOk, error) {
+	res := TopologyOk{}
+	return res, nil
+}
+
+func (s *server) deliverHandler(req Deliver) (DeliverOk, error) {
+	s.mu.Lock()
+	if req.Clock[req.Origin] > s.clock[req.Origin] { // otherwise duplicate
+		s.buffered[req.ID()] = req
+		s.deliverBuffered()
+	}
+	s.mu.Unlock()
+
+	res := DeliverOk{
+		Src:    s.n.ID(),
+		Origin: req.Origin,
+		Seq:    req.Clock[req.Origin],
+	}
+	return res, nil
+}
+
+func (s *server) deliverOkHandler(req DeliverOk) error {
+	delivery := delivery{req.Src, messageID{req.Origin, req.Seq}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.unconfirmedDelivery.Contains(delivery) {
+		return nil // duplicate
+	}
+	s.unconfirmedDelivery.Remove(delivery)
+	if s.unacked[delivery.id]--; s.unacked[delivery.id] == 0 {
+		delete(s.outbox, delivery.id)
+		delete(s.unacked, delivery.id)
+	}
+
+	return nil
+}
+
+// deliverBuffered delivers buffered messages until none is deliverable, caller must hold s.mu.
+func (s *server) deliverBuffered() {
+	for progress := true; progress; {
+		progress = false
+		for id, req := range s.buffered {
+			if !s.deliverable(req) {
+				continue
+			}
+			s.deliveredSelf = append(s.deliveredSelf, req.Message)
+			s.clock[req.Origin] = req.Clock[req.Origin]
+			delete(s.buffered, id)
+			progress = true
+		}
+	}
+}
+
+// deliverable if next in sequence from its origin and all messages the origin had delivered are delivered here.
+func (s *server) deliverable(req Deliver) bool {
+	for node, seq := range req.Clock {
+		if node == req.Origin {
+			if seq != s.clock[node]+1 {
+				return false
+			}
+		} else if seq > s.clock[node] {
+			return false
+		}
+	}
+	return true
+}
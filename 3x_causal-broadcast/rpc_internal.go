@@ -0,0 +1,17 @@
+package main
+
+type Deliver struct {
+	Origin  string         `json:"origin"`
+	Clock   map[string]int `json:"clock"`
+	Message int            `json:"message"`
+}
+
+func (d *Deliver) ID() messageID {
+	return messageID{d.Origin, d.Clock[d.Origin]}
+}
+
+type DeliverOk struct {
+	Src    string `json:"src"`
+	Origin string `json:"origin"`
+	Seq    int    `json:"seq"`
+}
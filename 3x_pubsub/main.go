@@ -0,0 +1,243 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type server struct {
+	n                   *maelstrom.Node
+	mu                  sync.Mutex
+	deliveredSelf       map[topic]mapset.Set[message]
+	subscriptions       map[nodeID]Subscriptions // latest gossiped version per node, including self
+	unconfirmedDelivery mapset.Set[delivery]
+	pendingDelivery     *utils.Batcher[publication]
+}
+
+type nodeID = string
+type topic = string
+type message = int
+
+type publication struct {
+	Topic   topic   `json:"topic"`
+	Message message `json:"message"`
+}
+
+type delivery struct {
+	dest        nodeID
+	publication publication
+}
+
+const (
+	defaultTopic = "" // every node is subscribed, serves the broadcast workload
+	maxBatch     = 100
+	minInterval  = time.Second / 20
+	latencySLO   = time.Second
+)
+
+// Topic-based publish/subscribe, a publication is only delivered to nodes subscribed to its topic. Subscriptions are
+// gossiped as versioned per-node topic sets, delivery is fault tolerant and batched as in 3e.
+// https://en.wikipedia.org/wiki/Publish%E2%80%93subscribe_pattern
+func main() {
+	n := maelstrom.NewNode()
+	s := server{
+		n:                   n,
+		deliveredSelf:       make(map[topic]mapset.Set[message]),
+		subscriptions:       make(map[nodeID]Subscriptions),
+		unconfirmedDelivery: mapset.NewSet[delivery](),
+	}
+	s.pendingDelivery = utils.NewBatcher(utils.BatcherConfig{
+		MaxBatch:    maxBatch,
+		MinInterval: minInterval,
+		LatencySLO:  latencySLO,
+	}, func(dest nodeID, publications []publication) {
+		err := utils.SendAsync(n, "deliver", dest, Deliver{publications})
+		if err != nil {
+			log.Printf("error async deliver to %s: %v", dest, err)
+		}
+	})
+
+	go func() {
+		for range time.Tick(time.Second) {
+			for _, delivery := range s.unconfirmedDelivery.ToSlice() {
+				s.pendingDelivery.Add(delivery.dest, delivery.publication) // batches delivery
+			}
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Second) {
+			s.gossipSubscriptions() // anti-entropy, e.g. after partitions
+		}
+	}()
+
+	// external
+	utils.RegisterHandler(n, "publish", s.publishHandler)
+	utils.RegisterHandler(n, "subscribe", s.subscribeHandler)
+	utils.RegisterHandler(n, "unsubscribe", s.unsubscribeHandler)
+	utils.RegisterHandler(n, "broadcast", s.broadcastHandler)
+	utils.RegisterHandler(n, "read", s.readHandler)
+	utils.RegisterHandler(n, "topology", s.topologyHandler)
+
+	// internal
+	utils.RegisterHandler(n, "deliver", s.deliverHandler)
+	utils.RegisterAsyncHandler(n, "deliver_ok", s.deliverOkHandler)
+	utils.RegisterAsyncHandler(n, "subscriptions", s.subscriptionsHandler)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *server) publishHandler(req Publish) (PublishOk, error) {
+	publication := publication{req.Topic, req.Message}
+	for _, dest := range s.n.NodeIDs() {
+		if !s.subscribed(dest, publication.Topic) {
+			continue
+		}
+		if dest == s.n.ID() {
+			s.deliver(publication)
+			continue
+		}
+		delivery := delivery{dest, publication}
+		s.unconfirmedDelivery.Add(delivery)
+		s.pendingDelivery.Add(delivery.dest, delivery.publication)
+	}
+
+	res := PublishOk{}
+	return res, nil
+}
+
+func (s *server) subscribeHandler(req Subscribe) (SubscribeOk, error) {
+	s.updateSubscriptions(func(topics mapset.Set[topic]) { topics.Add(req.Topic) })
+
+	res := SubscribeOk{}
+	return res, nil
+}
+
+func (s *server) unsubscribeHandler(req Unsubscribe) (UnsubscribeOk, error) {
+	s.updateSubscriptions(func(topics mapset.Set[topic]) { topics.Remove(req.Topic) })
+
+	res := UnsubscribeOk{}
+	return res, nil
+}
+
+func (s *server) broadcastHandler(req Broadcast) (BroadcastOk, error) {
+	if _, err := s.publishHandler(req.ToPublish()); err != nil {
+		return *new(BroadcastOk), err
+	}
+
+	res := BroadcastOk{}
+	return res, nil
+}
+
+func (s *server) readHandler(req Read) (ReadOk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []message{}
+	for topic, delivered := range s.deliveredSelf {
+		if req.Topic == nil || *req.Topic == topic {
+			messages = append(messages, delivered.ToSlice()...)
+		}
+	}
+
+	res := ReadOk{
+		Messages: messages,
+	}
+	return res, nil
+}
+
+func (s *server) topologyHandler(req Topology) (TopologyOk, error) {
+	res := TopologyOk{}
+	return res, nil
+}
+
+func (s *server) deliverHandler(req Deliver) (DeliverOk, error) {
+	for _, publication := range req.Publications {
+		s.deliver(publication)
+	}
+
+	res := DeliverOk{
+		Src:          s.n.ID(),
+		Publications: req.Publications,
+	}
+	return res, nil
+}
+
+func (s *server) deliverOkHandler(req DeliverOk) error {
+	for _, publication := range req.Publications {
+		s.unconfirmedDelivery.Remove(delivery{req.Src, publication})
+	}
+
+	return nil
+}
+
+func (s *server) subscriptionsHandler(req Subscriptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.subscriptions[req.Src]; !ok || req.Version > current.Version {
+		s.subscriptions[req.Src] = req
+	}
+
+	return nil
+}
+
+func (s *server) deliver(publication publication) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveredSelf[publication.Topic]; !ok {
+		s.deliveredSelf[publication.Topic] = mapset.NewSet[message]()
+	}
+	s.deliveredSelf[publication.Topic].Add(publication.Message)
+}
+
+func (s *server) subscribed(node nodeID, topic topic) bool {
+	if topic == defaultTopic {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.subscriptions[node].Topics, topic)
+}
+
+func (s *server) updateSubscriptions(update func(mapset.Set[topic])) {
+	s.mu.Lock()
+	own := s.subscriptions[s.n.ID()]
+	topics := mapset.NewSet(own.Topics...)
+	update(topics)
+	s.subscriptions[s.n.ID()] = Subscriptions{
+		Src:     s.n.ID(),
+		Version: own.Version + 1,
+		Topics:  topics.ToSlice(),
+	}
+	s.mu.Unlock()
+
+	s.gossipSubscriptions()
+}
+
+func (s *server) gossipSubscriptions() {
+	s.mu.Lock()
+	own, ok := s.subscriptions[s.n.ID()]
+	s.mu.Unlock()
+	if !ok {
+		return // nothing but the default topic
+	}
+
+	for _, dest := range s.n.NodeIDs() {
+		if dest == s.n.ID() {
+			continue
+		}
+		err := utils.SendAsync(s.n, "subscriptions", dest, own)
+		if err != nil {
+			log.Printf("error async gossip subscriptions to %s: %v", dest, err)
+		}
+	}
+}
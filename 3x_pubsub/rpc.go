@@ -0,0 +1,47 @@
+package main
+
+type Publish struct {
+	Topic   string `json:"topic"`
+	Message int    `json:"message"`
+}
+
+type PublishOk struct{}
+
+type Subscribe struct {
+	Topic string `json:"topic"`
+}
+
+type SubscribeOk struct{}
+
+type Unsubscribe struct {
+	Topic string `json:"topic"`
+}
+
+type UnsubscribeOk struct{}
+
+type Broadcast struct {
+	Message int `json:"message"`
+}
+
+func (b *Broadcast) ToPublish() Publish {
+	return Publish{
+		Topic:   defaultTopic,
+		Message: b.Message,
+	}
+}
+
+type BroadcastOk struct{}
+
+type Read struct {
+	Topic *string `json:"topic,omitempty"` // all topics if omitted
+}
+
+type ReadOk struct {
+	Messages []int `json:"messages"`
+}
+
+type Topology struct {
+	Topology map[string][]string `json:"topology"`
+}
+
+type TopologyOk struct{}
@@ -0,0 +1,16 @@
+package main
+
+type Deliver struct {
+	Publications []publication `json:"publications"`
+}
+
+type DeliverOk struct {
+	Src          string        `json:"src"`
+	Publications []publication `json:"publications"`
+}
+
+type Subscriptions struct {
+	Src     string   `json:"src"`
+	Version int      `json:"version"`
+	Topics  []string `json:"topics"`
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type server struct {
+	n             *maelstrom.Node
+	kv            *maelstrom.KV
+	mu            sync.Mutex
+	deliveredSelf []message // in sequence order
+	deliveredSet  mapset.Set[message]
+	nextSeq       int
+	buffered      map[int]message // received ahead of a gap
+	sealed        map[int]int     // length per sealed block, the sequence numbers after it are unused
+	seqMu         sync.Mutex
+	open          *openBlock // being filled by this node as sequencer, nil if none
+	leaseMu       sync.Mutex
+	lease         lease
+}
+
+type nodeID = string
+type message = int
+
+type lease struct {
+	Node    nodeID `json:"node"`
+	Expires int64  `json:"expires"` // unix millis
+	Epoch   int    `json:"epoch"`   // incremented on every change of node
+}
+
+// block is the record of a reserved block in lin-kv, holding its messages once sealed by the node reserving it.
+type block struct {
+	Node   nodeID    `json:"node"`
+	Epoch  int       `json:"epoch"` // of the lease it was reserved under, 0 for a fallback claim
+	Msgs   []message `json:"msgs,omitempty"`
+	Sealed bool      `json:"sealed"`
+}
+
+type openBlock struct {
+	index int
+	epoch int
+	msgs  []message
+}
+
+const (
+	leaseDuration   = 2 * time.Second
+	sequenceTimeout = time.Second / 2 // before falling back to claiming in lin-kv
+	gapCheckPeriod  = time.Second / 2
+	blockSize       = 100 // sequence numbers reserved at once
+	highWaterKey    = "blockHighWater"
+)
+
+func blockKey(index int) string {
+	return "block:" + strconv.Itoa(index)
+}
+
+// Total order broadcast, every message is assigned a global sequence number. The sequence numbers are split into
+// blocks of blockSize, reserved by creating the write-once lin-kv key block:<index>, so no two nodes ever assign the
+// same one. The node holding the sequencer lease assigns the numbers of its block in memory while its lease epoch
+// lasts and persists the block once full, or sealed when the lease moves on, the numbers left being unused. If the
+// sequencer is unreachable the broadcasting node claims a block of its own for the message, starting from the
+// high-water key rather than probing every block. Receivers deliver in sequence order and fill gaps, including a lost
+// tail, from the sealed blocks in lin-kv or from the node filling an open block. Until its block is sealed, a message
+// acknowledged by the sequencer is kept by the nodes it reached, which a partition delays but does not lose.
+// https://en.wikipedia.org/wiki/Atomic_broadcast
+func main() {
+	n := maelstrom.NewNode()
+	s := server{
+		n:             n,
+		kv:            maelstrom.NewLinKV(n),
+		deliveredSelf: []message{},
+		deliveredSet:  mapset.NewSet[message](),
+		buffered:      make(map[int]message),
+		sealed:        make(map[int]int),
+	}
+
+	go func() {
+		for range time.Tick(leaseDuration / 4) {
+			if err := s.renewLease(); err != nil {
+				log.Printf("error renewing lease: %v", err)
+			}
+			if err := s.sealStale(); err != nil {
+				log.Printf("error sealing block: %v", err) // retried on the next tick
+			}
+		}
+	}()
+
+	go func() {
+		for range time.Tick(gapCheckPeriod) {
+			if err := s.fillGaps(); err != nil {
+				log.Printf("error filling gaps: %v", err)
+			}
+		}
+	}()
+
+	// external
+	utils.RegisterHandler(n, "broadcast", s.broadcastHandler)
+	utils.RegisterHandler(n, "read", s.readHandler)
+	utils.RegisterHandler(n, "topology", s.topologyHandler)
+
+	// internal
+	utils.RegisterHandler(n, "sequence", s.sequenceHandler)
+	utils.RegisterHandler(n, "fetch_block", s.fetchBlockHandler)
+	utils.RegisterAsyncHandler(n, "deliver", s.deliverHandler)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *server) broadcastHandler(req Broadcast) (BroadcastOk, error) {
+	sequencer := s.sequencer()
+	var err error
+	switch sequencer {
+	case s.n.ID():
+		_, err = s.assign(req.Message)
+	case "":
+		err = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "no sequencer")
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), sequenceTimeout)
+		defer cancel()
+		_, err = utils.SendWithContext[Sequence, SequenceOk](ctx, s.n, "sequence", sequencer, Sequence{req.Message})
+	}
+	if err != nil {
+		log.Printf("[sequencer=%s, message=%d] falling back to lin-kv claim: %v", sequencer, req.Message, err)
+		if _, err := s.claim(req.Message); err != nil {
+			return *new(BroadcastOk), err
+		}
+	}
+
+	res := BroadcastOk{}
+	return res, nil
+}
+
+func (s *server) readHandler(req Read) (ReadOk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := ReadOk{
+		Messages: append([]message{}, s.deliveredSelf...),
+	}
+	return res, nil
+}
+
+func (s *server) topologyHandler(req Topology) (TopologyOk, error) {
+	res := TopologyOk{}
+	return res, nil
+}
+
+func (s *server) sequenceHandler(req Sequence) (SequenceOk, error) {
+	seq, err := s.assign(req.Message)
+	if err != nil {
+		return *new(SequenceOk), err
+	}
+
+	res := SequenceOk{
+		Seq: seq,
+	}
+	return res, nil
+}
+
+func (s *server) fetchBlockHandler(req FetchBlock) (FetchBlockOk, error) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	if s.open == nil || s.open.index != req.Block {
+		return *new(FetchBlockOk), maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "block not open, read it from lin-kv")
+	}
+
+	res := FetchBlockOk{
+		Msgs: append([]message{}, s.open.msgs...),
+	}
+	return res, nil
+}
+
+func (s *server) deliverHandler(req Deliver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Last {
+		s.sealed[req.Seq/blockSize] = req.Seq%blockSize + 1
+	}
+	s.buffer(req.Seq, req.Message)
+
+	return nil
+}
+
+// assign sequences the message in memory from the open block of this node, reserving a new block once full. It fails
+// unless this node holds the lease, and seals a block left open under an earlier epoch first.
+func (s *server) assign(msg message) (int, error) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+
+	epoch, ok := s.leaseEpoch()
+	if !ok {
+		return *new(int), maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not the sequencer")
+	}
+	if s.open != nil && (s.open.epoch != epoch || len(s.open.msgs) == blockSize) {
+		if err := s.sealOpen(); err != nil {
+			return *new(int), err
+		}
+	}
+	if s.open == nil {
+		index, err := s.reserve(block{Node: s.n.ID(), Epoch: epoch})
+		if err != nil {
+			return *new(int), err
+		}
+		s.open = &openBlock{index: index, epoch: epoch}
+	}
+	seq := s.open.index*blockSize + len(s.open.msgs)
+	s.open.msgs = append(s.open.msgs, msg)
+	s.deliverAll(Deliver{Seq: seq, Message: msg})
+	return seq, nil
+}
+
+// claim sequences the message by reserving a block of its own, the fallback when the sequencer is unreachable.
+func (s *server) claim(msg message) (int, error) {
+	index, err := s.reserve(block{Node: s.n.ID(), Msgs: []message{msg}, Sealed: true})
+	if err != nil {
+		return *new(int), err
+	}
+	seq := index * blockSize
+	s.deliverAll(Deliver{Seq: seq, Message: msg, Last: true})
+	return seq, nil
+}
+
+// reserve creates the record of the first free block from the high-water mark and moves the mark past it.
+func (s *server) reserve(rec block) (int, error) {
+	highWater, err := utils.ReadOrElse(s.kv, highWaterKey, 0)
+	if err != nil {
+		return *new(int), err
+	}
+	s.mu.Lock()
+	index := max(highWater, s.nextSeq/blockSize)
+	s.mu.Unlock()
+	for {
+		err := s.kv.CompareAndSwap(context.Background(), blockKey(index), nil, rec, true)
+		if err == nil {
+			break
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return *new(int), err
+		}
+		index++ // reserved since the high-water mark was read
+	}
+	err = s.kv.CompareAndSwap(context.Background(), highWaterKey, highWater, index+1, true)
+	if err != nil && maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		log.Printf("error moving high-water mark to %d: %v", index+1, err) // only a hint
+	}
+	return index, nil
+}
+
+// sealOpen persists the open block as sealed, so receivers skip the numbers left, caller must hold s.seqMu.
+func (s *server) sealOpen() error {
+	rec := block{
+		Node:   s.n.ID(),
+		Epoch:  s.open.epoch,
+		Msgs:   s.open.msgs,
+		Sealed: true,
+	}
+	if err := s.kv.Write(context.Background(), blockKey(s.open.index), rec); err != nil {
+		return err
+	}
+	s.open = nil
+	return nil
+}
+
+// sealStale seals the open block once the lease epoch it was reserved under is over.
+func (s *server) sealStale() error {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	if s.open == nil {
+		return nil
+	}
+	if epoch, ok := s.leaseEpoch(); ok && epoch == s.open.epoch {
+		return nil
+	}
+	return s.sealOpen()
+}
+
+func (s *server) deliverAll(req Deliver) {
+	for _, dest := range s.n.NodeIDs() {
+		err := utils.SendAsync(s.n, "deliver", dest, req)
+		if err != nil {
+			log.Printf("error async deliver to %s: %v", dest, err) // recovered by gap filling
+		}
+	}
+}
+
+// buffer delivers the message if next in sequence, caller must hold s.mu.
+func (s *server) buffer(seq int, msg message) {
+	if seq >= s.nextSeq { // otherwise duplicate
+		s.buffered[seq] = msg
+	}
+	s.deliverBuffered()
+}
+
+// deliverBuffered delivers the buffered messages next in sequence, skipping the unused numbers of sealed blocks, caller
+// must hold s.mu.
+func (s *server) deliverBuffered() {
+	for {
+		if length, ok := s.sealed[s.nextSeq/blockSize]; ok && s.nextSeq%blockSize >= length {
+			s.nextSeq = (s.nextSeq/blockSize + 1) * blockSize
+			continue
+		}
+		next, ok := s.buffered[s.nextSeq]
+		if !ok {
+			return
+		}
+		delete(s.buffered, s.nextSeq)
+		s.nextSeq++
+		if s.deliveredSet.Add(next) { // a broadcast retried after a sequencer timeout may be sequenced twice
+			s.deliveredSelf = append(s.deliveredSelf, next)
+		}
+	}
+}
+
+// fillGaps reads missing messages block by block, both gaps before buffered messages and a possibly lost tail, from
+// lin-kv once sealed, else from the node filling the block.
+func (s *server) fillGaps() error {
+	for {
+		s.mu.Lock()
+		index := s.nextSeq / blockSize
+		s.mu.Unlock()
+
+		rec, err := utils.ReadOrElse(s.kv, blockKey(index), block{})
+		if err != nil {
+			return err
+		}
+		if rec.Node == "" {
+			return nil // not yet reserved
+		}
+		if !rec.Sealed {
+			ctx, cancel := context.WithTimeout(context.Background(), sequenceTimeout)
+			res, err := utils.SendWithContext[FetchBlock, FetchBlockOk](ctx, s.n, "fetch_block", rec.Node, FetchBlock{index})
+			cancel()
+			if err != nil {
+				return err
+			}
+			rec.Msgs = res.Msgs
+		}
+
+		s.mu.Lock()
+		if rec.Sealed {
+			s.sealed[index] = len(rec.Msgs)
+		}
+		for i, msg := range rec.Msgs {
+			s.buffer(index*blockSize+i, msg)
+		}
+		s.deliverBuffered() // skips the unused numbers, also with every message of the block delivered
+		s.mu.Unlock()
+		if !rec.Sealed {
+			return nil // caught up with the sequencer
+		}
+	}
+}
+
+// renewLease renews the sequencer lease if held by this node, or takes it over once expired in a new epoch.
+func (s *server) renewLease() error {
+	current, err := utils.ReadOrElse(s.kv, "sequencerLease", lease{})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	if current.Node != s.n.ID() && current.Expires > now {
+		s.leaseMu.Lock()
+		s.lease = current
+		s.leaseMu.Unlock()
+		return nil
+	}
+	renewed := lease{
+		Node:    s.n.ID(),
+		Expires: now + leaseDuration.Milliseconds(),
+		Epoch:   current.Epoch,
+	}
+	if current.Node != s.n.ID() {
+		renewed.Epoch++
+	}
+	err = s.kv.CompareAndSwap(context.Background(), "sequencerLease", current, renewed, true)
+	if err != nil {
+		if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+			return nil // lost to another node, picked up on next renewal
+		}
+		return err
+	}
+	if current.Node != s.n.ID() {
+		log.Printf("[node=%s, epoch=%d, expires=%d] acquired sequencer lease", renewed.Node, renewed.Epoch, renewed.Expires)
+	}
+	s.leaseMu.Lock()
+	s.lease = renewed
+	s.leaseMu.Unlock()
+	return nil
+}
+
+func (s *server) sequencer() nodeID {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.lease.Expires < time.Now().UnixMilli() {
+		return ""
+	}
+	return s.lease.Node
+}
+
+// leaseEpoch returns the epoch of the lease if held by this node and not expired.
+func (s *server) leaseEpoch() (int, bool) {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.lease.Node != s.n.ID() || s.lease.Expires < time.Now().UnixMilli() {
+		return 0, false
+	}
+	return s.lease.Epoch, true
+}
@@ -0,0 +1,24 @@
+package main
+
+type Sequence struct {
+	Message int `json:"message"`
+}
+
+type SequenceOk struct {
+	Seq int `json:"seq"`
+}
+
+type Deliver struct {
+	Seq     int  `json:"seq"`
+	Message int  `json:"message"`
+	Last    bool `json:"last,omitempty"` // of its block, the sequence numbers after it are unused
+}
+
+// FetchBlock reads the messages of a block not yet sealed from the node filling it.
+type FetchBlock struct {
+	Block int `json:"block"`
+}
+
+type FetchBlockOk struct {
+	Msgs []int `json:"msgs"`
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const rightsTimeout = time.Second / 2
+
+// boundedCounter never goes below zero without coordinating on every operation. Each node holds an escrow of rights,
+// increments create rights on the incrementing node and decrements consume local rights. A node out of rights asks its
+// peers to transfer some, a decrement that still cannot be covered is rejected.
+// https://pages.lip6.fr/Marc.Shapiro/papers/numeric-invariants-SRDS-2015.pdf
+type boundedCounter struct {
+	n        *maelstrom.Node
+	mu       sync.Mutex
+	counters map[string]escrow
+}
+
+// escrow is a state-based CRDT, node i only updates Rights[i] and Used[i], so states merge with a per-entry max.
+type escrow struct {
+	Rights map[nodeID]map[nodeID]int `json:"rights"` // Rights[i][i] created by increments on i, Rights[i][j] transferred from i to j
+	Used   map[nodeID]int            `json:"used"`   // consumed by decrements on each node
+}
+
+func newEscrow() escrow {
+	return escrow{
+		Rights: make(map[nodeID]map[nodeID]int),
+		Used:   make(map[nodeID]int),
+	}
+}
+
+func (e escrow) value() int {
+	value := 0
+	for node, rights := range e.Rights {
+		value += rights[node]
+	}
+	for _, used := range e.Used {
+		value -= used
+	}
+	return value
+}
+
+// available rights of the node, never negative in a state the node produced or in a merge of such states.
+func (e escrow) available(node nodeID) int {
+	available := e.Rights[node][node] - e.Used[node]
+	for from, rights := range e.Rights {
+		if from != node {
+			available += rights[node] // transferred to node
+		}
+	}
+	for to, transferred := range e.Rights[node] {
+		if to != node {
+			available -= transferred // transferred from node
+		}
+	}
+	return available
+}
+
+func (e escrow) grant(from nodeID, to nodeID, rights int) {
+	if _, ok := e.Rights[from]; !ok {
+		e.Rights[from] = make(map[nodeID]int)
+	}
+	e.Rights[from][to] += rights
+}
+
+func (e escrow) merge(other escrow) {
+	for from, rights := range other.Rights {
+		if _, ok := e.Rights[from]; !ok {
+			e.Rights[from] = make(map[nodeID]int)
+		}
+		for to, transferred := range rights {
+			e.Rights[from][to] = max(e.Rights[from][to], transferred)
+		}
+	}
+	for node, used := range other.Used {
+		e.Used[node] = max(e.Used[node], used)
+	}
+}
+
+func newBoundedCounter(n *maelstrom.Node) *boundedCounter {
+	c := &boundedCounter{
+		n:        n,
+		counters: make(map[string]escrow),
+	}
+
+	go func() {
+		for range time.Tick(gossipInterval) {
+			c.gossip()
+		}
+	}()
+
+	return c
+}
+
+func (c *boundedCounter) add(name string, delta int) error {
+	if delta >= 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.escrowOf(name).grant(c.n.ID(), c.n.ID(), delta)
+		return nil
+	}
+
+	if c.consume(name, -delta) {
+		return nil
+	}
+	c.requestRights(name, -delta)
+	if c.consume(name, -delta) {
+		return nil
+	}
+	return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "insufficient rights to decrement "+name)
+}
+
+func (c *boundedCounter) read(name string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.escrowOf(name).value(), nil
+}
+
+func (c *boundedCounter) consume(name string, rights int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.escrowOf(name)
+	if e.available(c.n.ID()) < rights {
+		return false
+	}
+	e.Used[c.n.ID()] += rights
+	return true
+}
+
+// requestRights asks peers in turn for transfers until this node has the rights or all peers are asked.
+func (c *boundedCounter) requestRights(name string, rights int) {
+	for _, dest := range c.n.NodeIDs() {
+		c.mu.Lock()
+		missing := rights - c.escrowOf(name).available(c.n.ID())
+		c.mu.Unlock()
+		if missing <= 0 {
+			return
+		}
+		if dest == c.n.ID() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), rightsTimeout)
+		res, err := utils.SendWithContext[RequestRights, RequestRightsOk](ctx, c.n, "request_rights", dest, RequestRights{c.n.ID(), name, missing})
+		cancel()
+		if err != nil {
+			log.Printf("[name=%s, dest=%s] error requesting rights: %v", name, dest, err)
+			continue
+		}
+		c.mu.Lock()
+		c.escrowOf(name).merge(res.Escrow)
+		c.mu.Unlock()
+	}
+}
+
+func (c *boundedCounter) requestRightsHandler(req RequestRights) (RequestRightsOk, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.escrowOf(req.Name)
+	if granted := min(req.Rights, e.available(c.n.ID())); granted > 0 {
+		e.grant(c.n.ID(), req.Src, granted)
+	}
+	state, err := utils.DeepCopy(e) // serialized after c.mu is released
+	if err != nil {
+		return *new(RequestRightsOk), err
+	}
+
+	res := RequestRightsOk{
+		Escrow: state,
+	}
+	return res, nil
+}
+
+func (c *boundedCounter) gossipHandler(req EscrowGossip) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, e := range req.Counters {
+		c.escrowOf(name).merge(e)
+	}
+	return nil
+}
+
+// escrowOf returns the escrow of the named counter, caller must hold c.mu.
+func (c *boundedCounter) escrowOf(name string) escrow {
+	if _, ok := c.counters[name]; !ok {
+		c.counters[name] = newEscrow()
+	}
+	return c.counters[name]
+}
+
+func (c *boundedCounter) gossip() {
+	c.mu.Lock()
+	counters, err := utils.DeepCopy(c.counters)
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("error copying escrow: %v", err)
+		return
+	}
+
+	for _, dest := range c.n.NodeIDs() {
+		if dest == c.n.ID() {
+			continue
+		}
+		err := utils.SendAsync(c.n, "escrow_gossip", dest, EscrowGossip{counters})
+		if err != nil {
+			log.Printf("error async gossip to %s: %v", dest, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const gossipInterval = time.Second / 2
+
+// gossipCounter is a state-based PN-Counter CRDT per counter name, a map from node to its count of increments and
+// decrements. Each node only updates its own entry and merges gossiped state with a per-node max, so reads converge
+// without KV traffic and stay available during partitions.
+// https://en.wikipedia.org/wiki/Conflict-free_replicated_data_type#PN-Counter_(Positive-Negative_Counter)
+type gossipCounter struct {
+	n      *maelstrom.Node
+	mu     sync.Mutex
+	counts map[string]map[nodeID]pnCount
+}
+
+func newGossipCounter(n *maelstrom.Node) *gossipCounter {
+	c := &gossipCounter{
+		n:      n,
+		counts: make(map[string]map[nodeID]pnCount),
+	}
+
+	go func() {
+		for range time.Tick(gossipInterval) {
+			c.gossip()
+		}
+	}()
+
+	return c
+}
+
+func (c *gossipCounter) add(name string, delta int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.countsOf(name)
+	counts[c.n.ID()] = counts[c.n.ID()].add(delta)
+	return nil
+}
+
+func (c *gossipCounter) read(name string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value := 0
+	for _, count := range c.counts[name] {
+		value += count.value()
+	}
+	return value, nil
+}
+
+func (c *gossipCounter) gossipHandler(req Gossip) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, nodeCounts := range req.Counts {
+		counts := c.countsOf(name)
+		for node, count := range nodeCounts {
+			counts[node] = counts[node].merge(count)
+		}
+	}
+	return nil
+}
+
+// countsOf returns the counts of the named counter, caller must hold c.mu.
+func (c *gossipCounter) countsOf(name string) map[nodeID]pnCount {
+	if _, ok := c.counts[name]; !ok {
+		c.counts[name] = make(map[nodeID]pnCount)
+	}
+	return c.counts[name]
+}
+
+func (c *gossipCounter) gossip() {
+	c.mu.Lock()
+	counts := make(map[string]map[nodeID]pnCount)
+	for name, nodeCounts := range c.counts {
+		counts[name] = maps.Clone(nodeCounts)
+	}
+	c.mu.Unlock()
+
+	for _, dest := range c.n.NodeIDs() {
+		if dest == c.n.ID() {
+			continue
+		}
+		err := utils.SendAsync(c.n, "gossip", dest, Gossip{counts})
+		if err != nil {
+			log.Printf("error async gossip to %s: %v", dest, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	uuid "github.com/google/uuid"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+const (
+	linearizableReads = false // opt-in, a CAS per read so reads observe every completed add
+	writeBehind       = false // opt-in, batch adds into one KV read and write per counter and flush
+	addAck            = ackDurable
+	flushInterval     = time.Second / 20
+	flushSize         = 100 // pending adds that trigger an early flush
+	stripes           = 1   // sub-keys per counter and node, each with its own lock, for hot counters without write-behind
+)
+
+// ackMode is the durability of an add when acknowledged with write-behind.
+type ackMode string
+
+const (
+	// ackDurable acknowledges an add once the flush containing it is written to the KV, the same guarantee as without
+	// write-behind. Reads, fresh ones in particular, observe every acknowledged add. Flushes are group commits, started
+	// as soon as the previous one completes, so waiting adds share a KV round trip without waiting for the interval.
+	ackDurable ackMode = "durable"
+	// ackImmediate acknowledges an add when queued. Acknowledged adds are lost if the node crashes before the next
+	// flush, and until then they are not observed by reads on any node.
+	ackImmediate ackMode = "immediate"
+)
+
+// kvCounter stores the count of each counter and node in seq-kv, optionally striped over sub-keys so concurrent adds to
+// a hot counter contend on different locks. A read sums over all nodes and stripes.
+type kvCounter struct {
+	self       func() nodeID
+	cluster    func() []nodeID
+	kv         utils.KV
+	mus        cmap.ConcurrentMap[string, *sync.Mutex]
+	freshReads bool
+	sentinelMu sync.Mutex
+	sentinel   string // last value written to the sentinel key of this node
+	ack        ackMode
+	pendingMu  sync.Mutex
+	pending    map[string]*pendingAdds // by counter name, not yet flushed
+	adds       int
+	flushes    chan struct{}
+}
+
+type pendingAdds struct {
+	delta   pnCount
+	waiters []chan error
+}
+
+func newKVCounter(n *maelstrom.Node) *kvCounter {
+	c := &kvCounter{
+		self:       func() nodeID { return n.ID() },
+		cluster:    func() []nodeID { return n.NodeIDs() },
+		kv:         maelstrom.NewSeqKV(n),
+		mus:        cmap.New[*sync.Mutex](),
+		freshReads: linearizableReads,
+	}
+	if writeBehind {
+		c.startWriteBehind(addAck)
+	}
+	return c
+}
+
+// startWriteBehind makes adds accumulate in a pending delta, flushed periodically or when flushSize adds are pending.
+func (c *kvCounter) startWriteBehind(ack ackMode) {
+	c.ack = ack
+	c.pending = make(map[string]*pendingAdds)
+	c.flushes = make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.flushes:
+			}
+			c.flush()
+		}
+	}()
+}
+
+func counterKey(name string, node nodeID, stripe int) string {
+	return "counter:" + name + ":" + node + ":" + strconv.Itoa(stripe)
+}
+
+func sentinelKey(node nodeID) string {
+	return "sentinel:" + node
+}
+
+func (c *kvCounter) add(name string, delta int) error {
+	if c.flushes != nil {
+		return c.addPending(name, delta)
+	}
+	return c.write(name, pnCount{}.add(delta))
+}
+
+// write adds the delta to a random stripe of the counter for this node.
+func (c *kvCounter) write(name string, delta pnCount) error {
+	key := counterKey(name, c.self(), rand.IntN(stripes))
+	c.mus.SetIfAbsent(key, new(sync.Mutex))
+	mu, _ := c.mus.Get(key)
+	mu.Lock()
+	defer mu.Unlock()
+	stripeCount, err := utils.ReadOrElse(c.kv, key, pnCount{})
+	if err != nil {
+		return err
+	}
+	return c.kv.Write(context.Background(), key, pnCount{stripeCount.Inc + delta.Inc, stripeCount.Dec + delta.Dec})
+}
+
+func (c *kvCounter) addPending(name string, delta int) error {
+	c.pendingMu.Lock()
+	pending, ok := c.pending[name]
+	if !ok {
+		pending = &pendingAdds{}
+		c.pending[name] = pending
+	}
+	pending.delta = pending.delta.add(delta)
+	c.adds++
+	var done chan error
+	if c.ack == ackDurable {
+		done = make(chan error, 1)
+		pending.waiters = append(pending.waiters, done)
+	}
+	if c.adds >= flushSize || done != nil {
+		select {
+		case c.flushes <- struct{}{}:
+		default: // flush already signaled
+		}
+	}
+	c.pendingMu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	return <-done
+}
+
+func (c *kvCounter) flush() {
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending, c.adds = make(map[string]*pendingAdds), 0
+	c.pendingMu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, adds := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.write(name, adds.delta)
+			if err != nil && c.ack == ackImmediate {
+				log.Printf("[name=%s, inc=%d, dec=%d] retrying flush: %v", name, adds.delta.Inc, adds.delta.Dec, err)
+				c.pendingMu.Lock()
+				if _, ok := c.pending[name]; !ok {
+					c.pending[name] = &pendingAdds{}
+				}
+				retry := c.pending[name]
+				retry.delta = pnCount{retry.delta.Inc + adds.delta.Inc, retry.delta.Dec + adds.delta.Dec} // already acknowledged
+				c.pendingMu.Unlock()
+			}
+			for _, done := range adds.waiters {
+				done <- err
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *kvCounter) read(name string) (int, error) {
+	values, err := c.readMany([]string{name})
+	if err != nil {
+		return *new(int), err
+	}
+	return values[name], nil
+}
+
+// readMany reads the counters after a single sync, rather than one per counter.
+func (c *kvCounter) readMany(names []string) (map[string]int, error) {
+	if c.freshReads {
+		if err := c.sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make(map[string]int)
+	for _, name := range names {
+		value := 0
+		for _, node := range c.cluster() {
+			for stripe := 0; stripe < stripes; stripe++ {
+				stripeCount, err := utils.ReadOrElse(c.kv, counterKey(name, node, stripe), pnCount{})
+				if err != nil {
+					return nil, err
+				}
+				value += stripeCount.value()
+			}
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// sync makes later reads of this node observe all writes completed before it. seq-kv may serve a client arbitrarily
+// stale reads, but a successful CAS must see the latest value of its key, and later operations of the same client are
+// ordered after it.
+func (c *kvCounter) sync() error {
+	c.sentinelMu.Lock()
+	defer c.sentinelMu.Unlock()
+	next := uuid.New().String()
+	for retried := false; ; retried = true {
+		err := c.kv.CompareAndSwap(context.Background(), sentinelKey(c.self()), c.sentinel, next, true)
+		if err == nil {
+			break
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed || retried {
+			return err
+		}
+		// The sentinel is unknown, e.g. after a restart or a lost CAS reply. A read may be stale for as long as seq-kv
+		// likes, while this node's own blind write is seen by its next CAS, as only this node writes its sentinel.
+		reset := uuid.New().String()
+		if err := c.kv.Write(context.Background(), sentinelKey(c.self()), reset); err != nil {
+			return err
+		}
+		c.sentinel = reset
+	}
+	c.sentinel = next
+	return nil
+}
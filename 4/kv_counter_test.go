@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// staleKV is an adversarial in-memory seq-kv. Writes and CAS apply to the latest state, while reads return the oldest
+// state sequential consistency allows a client, i.e. as of the client's own last write or successful CAS.
+type staleKV struct {
+	mu       sync.Mutex
+	clock    int
+	versions map[string][]version
+	latency  time.Duration // per operation, simulates a round trip
+}
+
+type version struct {
+	at    int
+	value []byte
+}
+
+type staleClient struct {
+	kv    *staleKV
+	floor int
+}
+
+func newStaleKV() *staleKV {
+	return &staleKV{versions: make(map[string][]version)}
+}
+
+func (kv *staleKV) client() *staleClient {
+	return &staleClient{kv: kv}
+}
+
+func (c *staleClient) ReadInto(ctx context.Context, key string, v any) error {
+	time.Sleep(c.kv.latency)
+	c.kv.mu.Lock()
+	defer c.kv.mu.Unlock()
+	var visible *version
+	for i, version := range c.kv.versions[key] {
+		if version.at <= c.floor {
+			visible = &c.kv.versions[key][i]
+		}
+	}
+	if visible == nil {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return json.Unmarshal(visible.value, v)
+}
+
+func (c *staleClient) Write(ctx context.Context, key string, value any) error {
+	time.Sleep(c.kv.latency)
+	c.kv.mu.Lock()
+	defer c.kv.mu.Unlock()
+	return c.write(key, value)
+}
+
+func (c *staleClient) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	time.Sleep(c.kv.latency)
+	c.kv.mu.Lock()
+	defer c.kv.mu.Unlock()
+	versions := c.kv.versions[key]
+	if len(versions) == 0 {
+		if !createIfNotExists {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return c.write(key, to)
+	}
+	fromJson, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(versions[len(versions)-1].value, fromJson) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "precondition failed")
+	}
+	return c.write(key, to)
+}
+
+// write applies to the latest state and moves the client to it, caller must hold c.kv.mu.
+func (c *staleClient) write(key string, value any) error {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.kv.clock++
+	c.kv.versions[key] = append(c.kv.versions[key], version{c.kv.clock, valueJson})
+	c.floor = c.kv.clock
+	return nil
+}
+
+func newTestKVCounters(kv *staleKV, freshReads bool, nodes ...nodeID) []*kvCounter {
+	counters := []*kvCounter{}
+	for _, node := range nodes {
+		counters = append(counters, &kvCounter{
+			self:       func() nodeID { return node },
+			cluster:    func() []nodeID { return nodes },
+			kv:         kv.client(),
+			mus:        cmap.New[*sync.Mutex](),
+			freshReads: freshReads,
+		})
+	}
+	return counters
+}
+
+func TestKVCounter_StaleReads(t *testing.T) {
+	counters := newTestKVCounters(newStaleKV(), false, "n0", "n1")
+	if err := counters[0].add("", 5); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	got, err := counters[1].read("")
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != 0 {
+		t.Errorf("read = %d, want stale 0", got)
+	}
+}
+
+func TestKVCounter_FreshReads(t *testing.T) {
+	counters := newTestKVCounters(newStaleKV(), true, "n0", "n1", "n2")
+
+	want := 0
+	last := 0
+	for i := 0; i < 30; i++ {
+		delta := i%4 + 1
+		if err := counters[i%3].add("", delta); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+		want += delta
+
+		for _, c := range counters {
+			got, err := c.read("")
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if got < last {
+				t.Fatalf("read = %d went backwards from %d", got, last)
+			}
+			if got != want {
+				t.Fatalf("read = %d, want %d", got, want)
+			}
+			last = got
+		}
+	}
+}
+
+func TestKVCounter_SyncAfterRestart(t *testing.T) {
+	kv := newStaleKV()
+	counters := newTestKVCounters(kv, true, "n0")
+	if err := counters[0].sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	restarted := newTestKVCounters(kv, true, "n0") // unaware of the sentinel, and reading it stale
+	if err := restarted[0].sync(); err != nil {
+		t.Fatalf("sync after restart failed: %v", err)
+	}
+	if err := restarted[0].sync(); err != nil {
+		t.Fatalf("sync after reset failed: %v", err)
+	}
+}
+
+func TestKVCounter_WriteBehindDurable(t *testing.T) {
+	counters := newTestKVCounters(newStaleKV(), true, "n0", "n1")
+	counters[0].startWriteBehind(ackDurable)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 250; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := counters[0].add("", 2); err != nil {
+				t.Errorf("add failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := counters[1].read("")
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != 500 {
+		t.Errorf("read = %d, want all acknowledged adds 500", got)
+	}
+}
+
+func TestKVCounter_Named(t *testing.T) {
+	counters := newTestKVCounters(newStaleKV(), true, "n0", "n1")
+	adds := []struct {
+		node  int
+		name  string
+		delta int
+	}{
+		{0, "a", 1},
+		{1, "a", 2},
+		{1, "b", 5},
+		{0, "", 7},
+	}
+	for _, add := range adds {
+		if err := counters[add.node].add(add.name, add.delta); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+
+	for name, want := range map[string]int{"a": 3, "b": 5, "": 7, "c": 0} {
+		got, err := counters[0].read(name)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("read(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestKVCounter_ReadMany(t *testing.T) {
+	kv := newStaleKV()
+	counters := newTestKVCounters(kv, true, "n0", "n1")
+	for i, name := range []string{"a", "b", "c"} {
+		if err := counters[i%2].add(name, i+1); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+
+	got, err := counters[0].readMany([]string{"a", "b", "c", "d"})
+	if err != nil {
+		t.Fatalf("readMany failed: %v", err)
+	}
+	if want := map[string]int{"a": 1, "b": 2, "c": 3, "d": 0}; !maps.Equal(got, want) {
+		t.Errorf("readMany = %v, want %v", got, want)
+	}
+	if syncs := len(kv.versions[sentinelKey("n0")]); syncs != 1 {
+		t.Errorf("synced %d times, want once", syncs)
+	}
+}
+
+// Concurrent adds on one node against a KV with a 1ms round trip.
+func BenchmarkKVCounter_Add(b *testing.B) {
+	modes := []struct {
+		name string
+		ack  ackMode
+	}{
+		{name: "sync"},
+		{name: "durable", ack: ackDurable},
+		{name: "immediate", ack: ackImmediate},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			kv := newStaleKV()
+			kv.latency = time.Millisecond
+			c := newTestKVCounters(kv, false, "n0")[0]
+			if mode.ack != "" {
+				c.startWriteBehind(mode.ack)
+			}
+
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := c.add("", 1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"context"
 	"log"
-	"sync"
 
 	utils "github.com/tobiajo/gossip-gloomers/utils"
 
@@ -11,26 +9,82 @@ import (
 )
 
 type server struct {
-	self    func() nodeID
-	cluster func() []nodeID
-	kv      *maelstrom.KV
-	mu      sync.Mutex
+	counter counter
 }
 
 type nodeID = string
 
+type counter interface {
+	add(name string, delta int) error
+	read(name string) (int, error)
+}
+
+// manyReader is a counter reading many counters at once cheaper than one by one.
+type manyReader interface {
+	readMany(names []string) (map[string]int, error)
+}
+
+// pnCount tracks increments and decrements separately, a negative delta adds to Dec. Both only grow, so two nodes'
+// versions of a count merge with a per-field max.
+type pnCount struct {
+	Inc int `json:"inc"`
+	Dec int `json:"dec"`
+}
+
+func (c pnCount) add(delta int) pnCount {
+	if delta < 0 {
+		c.Dec -= delta
+	} else {
+		c.Inc += delta
+	}
+	return c
+}
+
+func (c pnCount) merge(other pnCount) pnCount {
+	return pnCount{
+		Inc: max(c.Inc, other.Inc),
+		Dec: max(c.Dec, other.Dec),
+	}
+}
+
+func (c pnCount) value() int {
+	return c.Inc - c.Dec
+}
+
+type counterMode string
+
+const (
+	kvMode      counterMode = "kv"      // per-node counts in seq-kv
+	gossipMode  counterMode = "gossip"  // PN-Counter CRDT gossiped between nodes, no KV traffic
+	boundedMode counterMode = "bounded" // never below zero, decrements consume escrowed rights
+)
+
+const mode = kvMode
+
 // Challenge #4: Grow-Only Counter
 // https://fly.io/dist-sys/4
+// Also serves the pn-counter workload, as deltas may be negative.
 func main() {
 	n := maelstrom.NewNode()
-	s := server{
-		self:    func() nodeID { return n.ID() },
-		cluster: func() []nodeID { return n.NodeIDs() },
-		kv:      maelstrom.NewSeqKV(n),
+	s := server{}
+
+	switch mode {
+	case kvMode:
+		s.counter = newKVCounter(n)
+	case gossipMode:
+		c := newGossipCounter(n)
+		utils.RegisterAsyncHandler(n, "gossip", c.gossipHandler)
+		s.counter = c
+	case boundedMode:
+		c := newBoundedCounter(n)
+		utils.RegisterAsyncHandler(n, "escrow_gossip", c.gossipHandler)
+		utils.RegisterHandler(n, "request_rights", c.requestRightsHandler)
+		s.counter = c
 	}
 
 	utils.RegisterHandler(n, "add", s.addHandler)
 	utils.RegisterHandler(n, "read", s.readHandler)
+	utils.RegisterHandler(n, "read_many", s.readManyHandler)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -38,14 +92,7 @@ func main() {
 }
 
 func (s *server) addHandler(req Add) (AddOk, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	nodeValue, err := utils.ReadOrElse(s.kv, s.self(), 0)
-	if err != nil {
-		return *new(AddOk), err
-	}
-	err = s.kv.Write(context.Background(), s.self(), nodeValue+req.Delta)
-	if err != nil {
+	if err := s.counter.add(req.Name, req.Delta); err != nil {
 		return *new(AddOk), err
 	}
 
@@ -54,13 +101,9 @@ func (s *server) addHandler(req Add) (AddOk, error) {
 }
 
 func (s *server) readHandler(req Read) (ReadOk, error) {
-	value := 0
-	for _, node := range s.cluster() {
-		nodeValue, err := utils.ReadOrElse(s.kv, node, 0)
-		if err != nil {
-			return *new(ReadOk), err
-		}
-		value += nodeValue
+	value, err := s.counter.read(req.Name)
+	if err != nil {
+		return *new(ReadOk), err
 	}
 
 	res := ReadOk{
@@ -68,3 +111,27 @@ func (s *server) readHandler(req Read) (ReadOk, error) {
 	}
 	return res, nil
 }
+
+func (s *server) readManyHandler(req ReadMany) (ReadManyOk, error) {
+	if r, ok := s.counter.(manyReader); ok {
+		values, err := r.readMany(req.Names)
+		if err != nil {
+			return *new(ReadManyOk), err
+		}
+		return ReadManyOk{Values: values}, nil
+	}
+
+	values := make(map[string]int)
+	for _, name := range req.Names {
+		value, err := s.counter.read(name)
+		if err != nil {
+			return *new(ReadManyOk), err
+		}
+		values[name] = value
+	}
+
+	res := ReadManyOk{
+		Values: values,
+	}
+	return res, nil
+}
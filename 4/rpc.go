@@ -1,13 +1,24 @@
 package main
 
 type Add struct {
-	Delta int `json:"delta"`
+	Name  string `json:"name,omitempty"` // default counter if omitted
+	Delta int    `json:"delta"`
 }
 
 type AddOk struct{}
 
-type Read struct{}
+type Read struct {
+	Name string `json:"name,omitempty"` // default counter if omitted
+}
 
 type ReadOk struct {
 	Value int `json:"value"`
 }
+
+type ReadMany struct {
+	Names []string `json:"names"`
+}
+
+type ReadManyOk struct {
+	Values map[string]int `json:"values"`
+}
@@ -0,0 +1,19 @@
+package main
+
+type Gossip struct {
+	Counts map[string]map[string]pnCount `json:"counts"` // by counter name and node
+}
+
+type EscrowGossip struct {
+	Counters map[string]escrow `json:"counters"`
+}
+
+type RequestRights struct {
+	Src    string `json:"src"`
+	Name   string `json:"name"`
+	Rights int    `json:"rights"`
+}
+
+type RequestRightsOk struct {
+	Escrow escrow `json:"escrow"`
+}
@@ -7,4 +7,5 @@ CHALLENGE="$(basename "$SCRIPT_DIR")"
 
 cd "$SCRIPT_DIR"/..
 go build -o bin/"$CHALLENGE" ./"$CHALLENGE"
-maelstrom test -w g-counter --bin bin/"$CHALLENGE" --node-count 3 --rate 100 --time-limit 20 --nemesis partition --concurrency 2n
+# WORKLOAD=pn-counter for negative deltas
+maelstrom test -w "${WORKLOAD:-g-counter}" --bin bin/"$CHALLENGE" --node-count 3 --rate 100 --time-limit 20 --nemesis partition --concurrency 2n
@@ -1,36 +1,72 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	. "github.com/tobiajo/gossip-gloomers/common"
 	utils "github.com/tobiajo/gossip-gloomers/utils"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 type server struct {
-	messageLogs           map[string][]message
-	committedOffsets      map[string]int
+	messageLogs           map[string]*keyLog
+	committedOffsets      map[string]map[string]int // group to key to offset
+	groups                map[string]*ConsumerGroup
 	messageLogsMutex      sync.Mutex
 	committedOffsetsMutex sync.Mutex
+	groupsMutex           sync.Mutex
 }
 
 type message = int
 
+// keyLog holds the messages from start on, the prefix before start is dropped by retention. A record takes an offset
+// as a message does, with a placeholder in msgs.
+type keyLog struct {
+	start    int
+	msgs     []message
+	appended []time.Time    // never decreasing
+	records  map[int]record // by offset
+}
+
+type record struct {
+	value   json.RawMessage
+	headers map[string]string
+}
+
+// retention keeps every message by default, as the Maelstrom checker expects polls to see the whole log.
+var retention = RetentionPolicy{
+	Default: Retention{},
+	Keys:    map[string]Retention{},
+}
+
 // Challenge #5a: Single-Node Kafka-Style Log
 // https://fly.io/dist-sys/5a
 func main() {
 	n := maelstrom.NewNode()
 	s := server{
-		messageLogs:      make(map[string][]message),
-		committedOffsets: make(map[string]int),
+		messageLogs:      make(map[string]*keyLog),
+		committedOffsets: make(map[string]map[string]int),
+		groups:           make(map[string]*ConsumerGroup),
 	}
 
 	utils.RegisterHandler(n, "send", s.sendHandler)
+	utils.RegisterHandler(n, "send_batch", s.sendBatchHandler)
+	utils.RegisterHandler(n, "send_txn", s.sendTxnHandler)
+	utils.RegisterHandler(n, "send_record", s.sendRecordHandler)
 	utils.RegisterHandler(n, "poll", s.pollHandler)
+	utils.RegisterHandler(n, "poll_records", s.pollRecordsHandler)
+	utils.RegisterHandler(n, "offset_for_time", s.offsetForTimeHandler)
 	utils.RegisterHandler(n, "commit_offsets", s.commitOffsetsHandler)
 	utils.RegisterHandler(n, "list_committed_offsets", s.listCommittedOffsetsHandler)
+	utils.RegisterHandler(n, "join_group", s.joinGroupHandler)
+	utils.RegisterHandler(n, "heartbeat", s.heartbeatHandler)
+	utils.RegisterHandler(n, "leave_group", s.leaveGroupHandler)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -40,13 +76,9 @@ func main() {
 func (s *server) sendHandler(req Send) (SendOk, error) {
 	s.messageLogsMutex.Lock()
 	defer s.messageLogsMutex.Unlock()
-	messageLog, ok := s.messageLogs[req.Key]
-	if !ok {
-		messageLog = []message{}
-	}
-	offset := len(messageLog)
-	messageLog = append(messageLog, req.Msg)
-	s.messageLogs[req.Key] = messageLog
+	messageLog := s.messageLog(req.Key)
+	offset := messageLog.append(req.Msg)
+	s.truncate(req.Key, messageLog)
 
 	res := SendOk{
 		Offset: offset,
@@ -54,31 +86,190 @@ func (s *server) sendHandler(req Send) (SendOk, error) {
 	return res, nil
 }
 
+func (s *server) sendBatchHandler(req SendBatch) (SendBatchOk, error) {
+	s.messageLogsMutex.Lock()
+	defer s.messageLogsMutex.Unlock()
+	offsets := make([]*int, len(req.Msgs))
+	for key, indices := range batchByKey(req.Msgs) {
+		messageLog := s.messageLog(key)
+		for _, j := range indices {
+			offset := messageLog.append(req.Msgs[j].Msg)
+			offsets[j] = &offset
+		}
+		s.truncate(key, messageLog)
+	}
+
+	res := SendBatchOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+// sendTxnHandler appends the messages under the lock of the logs, which a poll holds across all its keys, so no poll
+// sees a part of the transaction and a read committed poll needs no markers.
+func (s *server) sendTxnHandler(req SendTxn) (SendTxnOk, error) {
+	res, err := s.sendBatchHandler(SendBatch{Msgs: req.Msgs})
+	if err != nil {
+		return *new(SendTxnOk), err
+	}
+	offsets := make([]int, len(res.Offsets))
+	for i, offset := range res.Offsets {
+		offsets[i] = *offset
+	}
+
+	return SendTxnOk{Offsets: offsets}, nil
+}
+
+func (s *server) sendRecordHandler(req SendRecord) (SendRecordOk, error) {
+	s.messageLogsMutex.Lock()
+	defer s.messageLogsMutex.Unlock()
+	messageLog := s.messageLog(req.Key)
+	offset := messageLog.append(0)
+	if messageLog.records == nil {
+		messageLog.records = make(map[int]record)
+	}
+	messageLog.records[offset] = record{value: req.Value, headers: req.Headers}
+	timestamp := messageLog.appended[offset-messageLog.start].UnixMilli()
+	s.truncate(req.Key, messageLog)
+
+	res := SendRecordOk{
+		Offset:    offset,
+		Timestamp: timestamp,
+	}
+	return res, nil
+}
+
+// batchByKey returns the indices of the messages per key, in order.
+func batchByKey(msgs []KeyedMsg) map[string][]int {
+	indices := make(map[string][]int)
+	for i, msg := range msgs {
+		indices[msg.Key] = append(indices[msg.Key], i)
+	}
+	return indices
+}
+
+// pollHandler reads all keys under one hold of the lock of the logs, so a poll sees a transaction in all or none of them.
 func (s *server) pollHandler(req Poll) (PollOk, error) {
+	s.messageLogsMutex.Lock()
+	defer s.messageLogsMutex.Unlock()
 	msgs := make(map[string][][2]int)
+	more := make(map[string]bool)
+	budget := req.PollLimits.Budget()
 	for key, offset := range req.Offsets {
-		s.messageLogsMutex.Lock()
-		messageLog := s.messageLogs[key][offset:]
-		s.messageLogsMutex.Unlock()
+		messageLog, ok := s.messageLogs[key]
+		if !ok {
+			messageLog = &keyLog{}
+		}
+		from, err := retention.PollFrom(key, offset, messageLog.start)
+		if err != nil {
+			return *new(PollOk), err
+		}
 		var pairs [][2]int
-		for i, message := range messageLog {
-			pair := [2]int{offset + i, message}
+		limit := budget.Limit()
+		for i := from - messageLog.start; i < len(messageLog.msgs) && len(pairs) <= limit; i++ {
+			if _, ok := messageLog.records[messageLog.start+i]; ok {
+				continue
+			}
+			pair := [2]int{messageLog.start + i, messageLog.msgs[i]}
 			pairs = append(pairs, pair)
 		}
+		pairs, more[key] = Take(budget, pairs)
+		if !more[key] {
+			delete(more, key)
+		}
 		msgs[key] = pairs
 	}
 
 	res := PollOk{
 		Msgs: msgs,
+		More: more,
+	}
+	return res, nil
+}
+
+// pollRecordsHandler reads all keys under one hold of the lock of the logs, as pollHandler.
+func (s *server) pollRecordsHandler(req PollRecords) (PollRecordsOk, error) {
+	s.messageLogsMutex.Lock()
+	defer s.messageLogsMutex.Unlock()
+	records := make(map[string][]Record)
+	more := make(map[string]bool)
+	budget := req.PollLimits.Budget()
+	for key, offset := range req.Offsets {
+		messageLog, ok := s.messageLogs[key]
+		if !ok {
+			messageLog = &keyLog{}
+		}
+		from, err := retention.PollFrom(key, offset, messageLog.start)
+		if err != nil {
+			return *new(PollRecordsOk), err
+		}
+		keyRecords := []Record{}
+		limit := budget.Limit()
+		for i := from - messageLog.start; i < len(messageLog.msgs) && len(keyRecords) <= limit; i++ {
+			keyRecords = append(keyRecords, messageLog.record(messageLog.start+i))
+		}
+		keyRecords, more[key] = Take(budget, keyRecords)
+		if !more[key] {
+			delete(more, key)
+		}
+		records[key] = keyRecords
+	}
+
+	res := PollRecordsOk{
+		Records: records,
+		More:    more,
+	}
+	return res, nil
+}
+
+// offsetForTimeHandler returns the offset of the first message or record per key with a timestamp at or after the
+// given one, else the end of the log.
+func (s *server) offsetForTimeHandler(req OffsetForTime) (OffsetForTimeOk, error) {
+	s.messageLogsMutex.Lock()
+	defer s.messageLogsMutex.Unlock()
+	offsets := make(map[string]int)
+	for key, timestamp := range req.Timestamps {
+		messageLog, ok := s.messageLogs[key]
+		if !ok {
+			messageLog = &keyLog{}
+		}
+		offsets[key] = messageLog.start + sort.Search(len(messageLog.appended), func(i int) bool {
+			return messageLog.appended[i].UnixMilli() >= timestamp
+		})
+	}
+
+	res := OffsetForTimeOk{
+		Offsets: offsets,
 	}
 	return res, nil
 }
 
 func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error) {
+	if req.Member != "" {
+		s.groupsMutex.Lock()
+		g := s.group(req.Group)
+		for key := range req.Offsets {
+			if !g.Owns(req.Member, req.Generation, key) {
+				s.groupsMutex.Unlock()
+				return *new(CommitOffsetsOk), NotOwnerError(req.Group, req.Member, req.Generation, key)
+			}
+		}
+		s.groupsMutex.Unlock()
+	}
+
 	for key, offset := range req.Offsets {
 		s.committedOffsetsMutex.Lock()
-		s.committedOffsets[key] = offset
+		if _, ok := s.committedOffsets[req.Group]; !ok {
+			s.committedOffsets[req.Group] = make(map[string]int)
+		}
+		s.committedOffsets[req.Group][key] = offset
 		s.committedOffsetsMutex.Unlock()
+
+		s.messageLogsMutex.Lock()
+		if messageLog, ok := s.messageLogs[key]; ok {
+			s.truncate(key, messageLog)
+		}
+		s.messageLogsMutex.Unlock()
 	}
 
 	res := CommitOffsetsOk{}
@@ -89,7 +280,7 @@ func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListComm
 	offsets := make(map[string]int)
 	for _, key := range req.Keys {
 		s.committedOffsetsMutex.Lock()
-		offsets[key] = s.committedOffsets[key]
+		offsets[key] = s.committedOffsets[req.Group][key]
 		s.committedOffsetsMutex.Unlock()
 	}
 
@@ -98,3 +289,123 @@ func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListComm
 	}
 	return res, nil
 }
+
+func (s *server) joinGroupHandler(req JoinGroup) (JoinGroupOk, error) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+	g := s.group(req.Group)
+	g.Join(req.Member, req.Keys, time.Now())
+
+	res := JoinGroupOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) heartbeatHandler(req Heartbeat) (HeartbeatOk, error) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+	g := s.group(req.Group)
+	if !g.Heartbeat(req.Member, time.Now()) {
+		return *new(HeartbeatOk), UnknownMemberError(req.Group, req.Member)
+	}
+
+	res := HeartbeatOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) leaveGroupHandler(req LeaveGroup) (LeaveGroupOk, error) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+	s.group(req.Group).Leave(req.Member)
+
+	res := LeaveGroupOk{}
+	return res, nil
+}
+
+// messageLog returns the log of the key, created on first use, caller must hold s.messageLogsMutex.
+func (s *server) messageLog(key string) *keyLog {
+	messageLog, ok := s.messageLogs[key]
+	if !ok {
+		messageLog = &keyLog{}
+		s.messageLogs[key] = messageLog
+	}
+	return messageLog
+}
+
+// append appends the message timestamped no earlier than the one before, returning its offset.
+func (l *keyLog) append(msg message) int {
+	now := time.Now()
+	if len(l.appended) > 0 && now.Before(l.appended[len(l.appended)-1]) {
+		now = l.appended[len(l.appended)-1]
+	}
+	l.msgs = append(l.msgs, msg)
+	l.appended = append(l.appended, now)
+	return l.start + len(l.msgs) - 1
+}
+
+// record returns the message or record at the offset as a record, a message having its int as value.
+func (l *keyLog) record(offset int) Record {
+	r, ok := l.records[offset]
+	if !ok {
+		r.value = json.RawMessage(strconv.Itoa(l.msgs[offset-l.start]))
+	}
+	return Record{Offset: offset, Value: r.value, Headers: r.headers, Timestamp: l.appended[offset-l.start].UnixMilli()}
+}
+
+// group returns the group with the expired members removed, caller must hold s.groupsMutex.
+func (s *server) group(name string) *ConsumerGroup {
+	g, ok := s.groups[name]
+	if !ok {
+		group := NewConsumerGroup()
+		g = &group
+		s.groups[name] = g
+	}
+	g.Expire(time.Now())
+	return g
+}
+
+// minCommittedOffset returns the offset of the key committed by every group, caller must hold s.committedOffsetsMutex.
+func (s *server) minCommittedOffset(key string) int {
+	committed := -1
+	for _, offsets := range s.committedOffsets {
+		if committed == -1 || offsets[key] < committed {
+			committed = offsets[key]
+		}
+	}
+	return max(committed, 0)
+}
+
+// truncate drops the prefix of the log no longer retained, caller must hold s.messageLogsMutex.
+func (s *server) truncate(key string, messageLog *keyLog) {
+	r := retention.Of(key)
+	if r == (Retention{}) {
+		return
+	}
+	s.committedOffsetsMutex.Lock()
+	committed := s.minCommittedOffset(key)
+	s.committedOffsetsMutex.Unlock()
+	cutoff := time.Now().Add(-r.MaxAge)
+	unexpired := messageLog.start + sort.Search(len(messageLog.appended), func(i int) bool {
+		return messageLog.appended[i].After(cutoff)
+	})
+
+	end := messageLog.start + len(messageLog.msgs)
+	start := r.Start(messageLog.start, end, committed, unexpired)
+	dropped := start - messageLog.start
+	if dropped == 0 {
+		return
+	}
+	messageLog.msgs = append([]message(nil), messageLog.msgs[dropped:]...) // copies to release the dropped prefix
+	messageLog.appended = append([]time.Time(nil), messageLog.appended[dropped:]...)
+	for offset := range messageLog.records {
+		if offset < start {
+			delete(messageLog.records, offset)
+		}
+	}
+	messageLog.start = start
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const groupsKey = "consumerGroups" // names of the groups with committed offsets, the default group is ""
+
+func groupKey(group string) string {
+	return "consumerGroup:" + strconv.Quote(group)
+}
+
+// groupRecord is a consumer group as stored in the lin-kv, with the offsets committed by it, so that a commit fenced by
+// the ownership of its keys is a single CAS, which a rebalance can't interleave.
+type groupRecord struct {
+	ConsumerGroup
+	Committed map[string]int `json:"committed,omitempty"` // key to offset
+}
+
+func newGroupRecord() groupRecord {
+	return groupRecord{ConsumerGroup: NewConsumerGroup()}
+}
+
+func (g groupRecord) clone() groupRecord {
+	return groupRecord{ConsumerGroup: g.Clone(), Committed: maps.Clone(g.Committed)}
+}
+
+// updateGroup applies the update to the group with the expired members removed, retrying on concurrent updates.
+func updateGroup(kv utils.KV, group string, update func(g *groupRecord) error) (groupRecord, error) {
+	for {
+		current, err := utils.ReadOrElse(kv, groupKey(group), newGroupRecord())
+		if err != nil {
+			return *new(groupRecord), err
+		}
+		g := current.clone()
+		g.Expire(time.Now())
+		if err := update(&g); err != nil {
+			return *new(groupRecord), err
+		}
+		err = kv.CompareAndSwap(context.Background(), groupKey(group), current, g, true)
+		if err == nil {
+			return g, nil
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return *new(groupRecord), err
+		}
+	}
+}
+
+func readGroup(kv utils.KV, group string) (groupRecord, error) {
+	g, err := utils.ReadOrElse(kv, groupKey(group), newGroupRecord())
+	if err != nil {
+		return *new(groupRecord), err
+	}
+	g.Expire(time.Now())
+	return g, nil
+}
+
+// commitOffsets commits the offsets of the group, fenced unless the member owns the keys in the generation if set.
+func commitOffsets(kv utils.KV, group, member string, generation int, offsets map[string]int) error {
+	_, err := updateGroup(kv, group, func(g *groupRecord) error {
+		if member != "" {
+			for key := range offsets {
+				if !g.Owns(member, generation, key) {
+					return NotOwnerError(group, member, generation, key)
+				}
+			}
+		}
+		if g.Committed == nil {
+			g.Committed = make(map[string]int, len(offsets))
+		}
+		for key, offset := range offsets {
+			g.Committed[key] = offset
+		}
+		return nil
+	})
+	return err
+}
+
+// registerGroup adds the group to the groups with committed offsets, if absent.
+func registerGroup(kv utils.KV, group string) error {
+	for {
+		groups, err := utils.ReadOrElse(kv, groupsKey, []string{})
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if g == group {
+				return nil
+			}
+		}
+		err = kv.CompareAndSwap(context.Background(), groupsKey, groups, append(groups, group), true)
+		if err == nil || maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return err
+		}
+	}
+}
+
+// minCommittedOffset returns the offset of the key committed by every group.
+func minCommittedOffset(kv utils.KV, key string) (int, error) {
+	groups, err := utils.ReadOrElse(kv, groupsKey, []string{})
+	if err != nil {
+		return *new(int), err
+	}
+	committed := -1
+	for _, group := range groups {
+		g, err := utils.ReadOrElse(kv, groupKey(group), newGroupRecord())
+		if err != nil {
+			return *new(int), err
+		}
+		offset := g.Committed[key]
+		if committed == -1 || offset < committed {
+			committed = offset
+		}
+	}
+	return max(committed, 0), nil
+}
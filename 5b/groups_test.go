@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestUpdateGroup_Concurrent(t *testing.T) {
+	kv := utils.NewMemKV()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := updateGroup(kv, "g", func(g *groupRecord) error {
+				g.Join("c"+strconv.Itoa(i), []string{"k1", "k2", "k3", "k4"}, time.Now())
+				return nil
+			})
+			if err != nil {
+				t.Errorf("join failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	g, err := readGroup(kv, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Members) != 8 {
+		t.Errorf("group has %d members, want 8", len(g.Members))
+	}
+	if g.Generation != 8 {
+		t.Errorf("generation = %d, want 8", g.Generation)
+	}
+}
+
+func TestMinCommittedOffset(t *testing.T) {
+	kv := utils.NewMemKV()
+	for group, offset := range map[string]int{"": 7, "g1": 3, "g2": 9} {
+		if err := registerGroup(kv, group); err != nil {
+			t.Fatal(err)
+		}
+		if err := commitOffsets(kv, group, "", 0, map[string]int{"k": offset}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registerGroup(kv, "g1"); err != nil { // already registered
+		t.Fatal(err)
+	}
+
+	committed, err := minCommittedOffset(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed != 3 {
+		t.Errorf("minCommittedOffset = %d, want 3", committed)
+	}
+	if committed, _ := minCommittedOffset(kv, "other"); committed != 0 {
+		t.Errorf("minCommittedOffset of uncommitted key = %d, want 0", committed)
+	}
+}
+
+func TestCommitOffsets_Fenced(t *testing.T) {
+	kv := utils.NewMemKV()
+	g, err := updateGroup(kv, "g", func(g *groupRecord) error {
+		g.Join("c1", []string{"k"}, time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := commitOffsets(kv, "g", "c1", g.Generation, map[string]int{"k": 3}); err != nil {
+		t.Fatalf("commit of the owner failed: %v", err)
+	}
+	if _, err := updateGroup(kv, "g", func(g *groupRecord) error { // rebalances
+		g.Join("c2", []string{"k"}, time.Now())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = commitOffsets(kv, "g", "c1", g.Generation, map[string]int{"k": 5})
+	if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("commit of a previous generation = %v, want fenced", err)
+	}
+	if g, err := readGroup(kv, "g"); err != nil || g.Committed["k"] != 3 {
+		t.Errorf("committed offset = %d, %v, want 3 of the owner", g.Committed["k"], err)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const segmentSize = 1000 // messages per segment
+
+// The log of a key is split into fixed-size segments under messageLog:<key>:<segment>, the head pointer under
+// messageLog:<key> is the index of the tail segment. An append only touches the tail segment, so it costs O(segment)
+// rather than O(log), and the offset of a message follows from its segment and position in it.
+//
+// Retention moves the log start under messageLog:<key>:start forward and overwrites the segments wholly before it with
+// tombstones, as the KV has no delete. Retention is applied when the tail segment rolls over or an offset is committed.
+
+type segment struct {
+	Msgs       []message      `json:"msgs"`
+	Timestamps []int64        `json:"timestamps,omitempty"` // unix millis per message, never decreasing
+	Records    map[int]record `json:"records,omitempty"`    // by offset, the messages at these are placeholders
+	Last       int64          `json:"last"`                 // unix millis of the last append
+	Truncated  bool           `json:"truncated,omitempty"`  // dropped by retention
+	Txns       map[int]string `json:"txns,omitempty"`       // transactions of the messages appended by one, by offset
+}
+
+// record is a message with an arbitrary JSON value.
+type record struct {
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (s segment) full() bool {
+	return s.Truncated || len(s.Msgs) >= segmentSize
+}
+
+// timestamp returns the timestamp of the message at the index, those appended before the timestamps were kept having
+// the last one.
+func (s segment) timestamp(i int) int64 {
+	if i < len(s.Timestamps) {
+		return s.Timestamps[i]
+	}
+	return s.Last
+}
+
+func messageLogKey(key string) string {
+	return "messageLog:" + key
+}
+
+func segmentKey(key string, segment int) string {
+	return messageLogKey(key) + ":" + strconv.Itoa(segment)
+}
+
+func logStartKey(key string) string {
+	return messageLogKey(key) + ":start"
+}
+
+func appendLog(kv utils.KV, key string, msg message) (int, error) {
+	offsets, err := appendLogBatch(kv, key, []message{msg}, "")
+	if err != nil {
+		return *new(int), err
+	}
+	return offsets[0], nil
+}
+
+// appendLogBatch appends the messages with one CAS per segment they fill, returning their offsets. The messages are
+// tagged with the transaction, if any.
+func appendLogBatch(kv utils.KV, key string, msgs []message, txn string) ([]int, error) {
+	offsets, _, err := appendLogEntries(kv, key, msgs, nil, txn)
+	return offsets, err
+}
+
+// appendRecord appends the record, returning its offset and timestamp.
+func appendRecord(kv utils.KV, key string, r record) (int, int64, error) {
+	offsets, timestamps, err := appendLogEntries(kv, key, []message{0}, []*record{&r}, "")
+	if err != nil {
+		return *new(int), *new(int64), err
+	}
+	return offsets[0], timestamps[0], nil
+}
+
+// appendLogEntries appends the messages as appendLogBatch, also returning their timestamps. A message with a record at
+// its index in records is a placeholder for it.
+func appendLogEntries(kv utils.KV, key string, msgs []message, records []*record, txn string) ([]int, []int64, error) {
+	tail, err := utils.ReadOrElse(kv, messageLogKey(key), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	offsets := make([]int, 0, len(msgs))
+	timestamps := make([]int64, 0, len(msgs))
+	for len(msgs) > 0 {
+		seg, err := utils.ReadOrElse(kv, segmentKey(key, tail), segment{Msgs: []message{}})
+		if err != nil {
+			return nil, nil, err
+		}
+		if seg.full() {
+			err = kv.CompareAndSwap(context.Background(), messageLogKey(key), tail, tail+1, true)
+			if err != nil && maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed { // else advanced by another append
+				return nil, nil, err
+			}
+			tail++
+			continue
+		}
+
+		n := min(len(msgs), segmentSize-len(seg.Msgs))
+		offset := tail*segmentSize + len(seg.Msgs)
+		now := max(time.Now().UnixMilli(), seg.Last)
+		segTimestamps := make([]int64, len(seg.Msgs), len(seg.Msgs)+n)
+		for i := range seg.Msgs {
+			segTimestamps[i] = seg.timestamp(i)
+		}
+		for i := 0; i < n; i++ {
+			segTimestamps = append(segTimestamps, now)
+		}
+		segRecords := maps.Clone(seg.Records)
+		for i, r := range records[:min(n, len(records))] {
+			if r != nil {
+				if segRecords == nil {
+					segRecords = make(map[int]record)
+				}
+				segRecords[offset+i] = *r
+			}
+		}
+		txns := maps.Clone(seg.Txns)
+		if txn != "" {
+			if txns == nil {
+				txns = make(map[int]string)
+			}
+			for i := 0; i < n; i++ {
+				txns[offset+i] = txn
+			}
+		}
+		appended := segment{
+			Msgs:       append(seg.Msgs, msgs[:n]...),
+			Timestamps: segTimestamps,
+			Records:    segRecords,
+			Last:       now,
+			Txns:       txns,
+		}
+		err = kv.CompareAndSwap(context.Background(), segmentKey(key, tail), seg, appended, true)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return nil, nil, err
+		}
+		if err == nil {
+			for i := 0; i < n; i++ {
+				offsets = append(offsets, offset+i)
+				timestamps = append(timestamps, now)
+			}
+			msgs = msgs[n:]
+			records = records[min(n, len(records)):]
+		}
+	}
+	return offsets, timestamps, nil
+}
+
+// rolledOver tells if appending count messages from the offset started a new segment.
+func rolledOver(offset, count int) bool {
+	return (offset+count-1)/segmentSize > max(offset-1, 0)/segmentSize
+}
+
+func logStart(kv utils.KV, key string) (int, error) {
+	return utils.ReadOrElse(kv, logStartKey(key), 0)
+}
+
+// readLog reads the messages from the offset, leaving out the records, see scanLog.
+func readLog(kv utils.KV, key string, offset int, limit int, readCommitted bool) ([][2]int, error) {
+	var pairs [][2]int
+	err := scanLog(kv, key, offset, readCommitted, func(seg segment, offset int, i int) int {
+		if _, ok := seg.Records[offset]; !ok {
+			pairs = append(pairs, [2]int{offset, seg.Msgs[i]})
+		}
+		return len(pairs) - limit
+	})
+	return pairs, err
+}
+
+// readRecords reads the messages and records from the offset as records, a message having its int as value, see
+// scanLog.
+func readRecords(kv utils.KV, key string, offset int, limit int, readCommitted bool) ([]Record, error) {
+	records := []Record{}
+	err := scanLog(kv, key, offset, readCommitted, func(seg segment, offset int, i int) int {
+		r, ok := seg.Records[offset]
+		if !ok {
+			r.Value = json.RawMessage(strconv.Itoa(seg.Msgs[i]))
+		}
+		records = append(records, Record{Offset: offset, Value: r.Value, Headers: r.Headers, Timestamp: seg.timestamp(i)})
+		return len(records) - limit
+	})
+	return records, err
+}
+
+// scanLog calls f with the messages from the offset, by their segment, offset and index in it, touching only the
+// segments covering them. f returns how many it read past the limit, and it stops at the first segment taking it past
+// the limit, so a caller can tell if there are more messages than the limit. If readCommitted, those of aborted
+// transactions are skipped and it stops at the first of an open transaction, as later messages may be followed by its
+// commit.
+func scanLog(kv utils.KV, key string, offset int, readCommitted bool, f func(seg segment, offset int, i int) int) error {
+	outcomes := make(map[string]string)
+	for i := offset / segmentSize; ; i++ {
+		seg, err := utils.ReadOrElse(kv, segmentKey(key, i), segment{})
+		if err != nil {
+			return err
+		}
+		past := 0
+		for j := range seg.Msgs {
+			if i*segmentSize+j < offset {
+				continue
+			}
+			if txn, ok := seg.Txns[i*segmentSize+j]; readCommitted && ok {
+				if _, ok := outcomes[txn]; !ok {
+					if outcomes[txn], err = txnOutcome(kv, txn); err != nil {
+						return err
+					}
+				}
+				if outcomes[txn] == txnOpen {
+					return nil
+				}
+				if outcomes[txn] == txnAborted {
+					continue
+				}
+			}
+			past = f(seg, i*segmentSize+j, j)
+		}
+		if !seg.full() || past > 0 {
+			return nil
+		}
+	}
+}
+
+// offsetForTime returns the offset of the first message or record with a timestamp at or after the given one, else the
+// end of the log. It searches the segments from the log start by their last timestamp, reading only O(log) of them.
+func offsetForTime(kv utils.KV, key string, timestamp int64) (int, error) {
+	start, err := logStart(kv, key)
+	if err != nil {
+		return *new(int), err
+	}
+	tail, err := utils.ReadOrElse(kv, messageLogKey(key), 0)
+	if err != nil {
+		return *new(int), err
+	}
+	tailSeg, err := utils.ReadOrElse(kv, segmentKey(key, tail), segment{})
+	if err != nil {
+		return *new(int), err
+	}
+	end := tail*segmentSize + len(tailSeg.Msgs)
+	last := tail
+	if len(tailSeg.Msgs) == 0 {
+		last-- // as between advancing the head and the first append to the new tail
+	}
+
+	lo, hi := start/segmentSize, last+1 // the first segment with a later last timestamp is in [lo, hi]
+	var found segment
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		seg := tailSeg
+		if mid != tail {
+			if seg, err = utils.ReadOrElse(kv, segmentKey(key, mid), segment{}); err != nil {
+				return *new(int), err
+			}
+		}
+		if seg.Last >= timestamp {
+			hi, found = mid, seg
+		} else {
+			lo = mid + 1
+		}
+	}
+	if lo > last {
+		return max(end, start), nil
+	}
+	i := sort.Search(len(found.Msgs), func(i int) bool { return found.timestamp(i) >= timestamp })
+	return max(lo*segmentSize+i, start), nil
+}
+
+// truncateLog moves the log start forward as far as the retention allows and tombstones the dropped segments.
+func truncateLog(kv utils.KV, key string, r Retention) error {
+	if r == (Retention{}) {
+		return nil
+	}
+	start, err := logStart(kv, key)
+	if err != nil {
+		return err
+	}
+	tail, err := utils.ReadOrElse(kv, messageLogKey(key), 0)
+	if err != nil {
+		return err
+	}
+	committed := 0
+	if r.Committed {
+		committed, err = minCommittedOffset(kv, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	seg, err := utils.ReadOrElse(kv, segmentKey(key, tail), segment{})
+	if err != nil {
+		return err
+	}
+	end := tail*segmentSize + len(seg.Msgs)
+
+	// the start of the first segment with a message younger than MaxAge
+	unexpired := end
+	if r.MaxAge > 0 {
+		cutoff := time.Now().Add(-r.MaxAge).UnixMilli()
+		if seg.Last > cutoff {
+			unexpired = tail * segmentSize
+		}
+		for i := start / segmentSize; i < tail; i++ {
+			seg, err := utils.ReadOrElse(kv, segmentKey(key, i), segment{})
+			if err != nil {
+				return err
+			}
+			if !seg.Truncated && seg.Last > cutoff {
+				unexpired = i * segmentSize
+				break
+			}
+		}
+	}
+
+	for {
+		truncated := r.Start(start, end, committed, unexpired)
+		if truncated <= start {
+			return nil
+		}
+		err = kv.CompareAndSwap(context.Background(), logStartKey(key), start, truncated, true)
+		if err == nil {
+			return tombstone(kv, key, start, truncated)
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return err
+		}
+		if start, err = logStart(kv, key); err != nil { // moved by another truncation
+			return err
+		}
+	}
+}
+
+// tombstone overwrites the segments wholly within [from, to), these are full, so no append writes them concurrently.
+func tombstone(kv utils.KV, key string, from, to int) error {
+	for i := from / segmentSize; i < to/segmentSize; i++ {
+		err := kv.Write(context.Background(), segmentKey(key, i), segment{Truncated: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+const benchLogSize = 100_000
+
+func newBenchKV(b *testing.B) *utils.MemKV {
+	kv := utils.NewMemKV()
+	for i := 0; i < benchLogSize/segmentSize; i++ {
+		seg := segment{Msgs: make([]message, segmentSize)}
+		if err := kv.Write(context.Background(), segmentKey("k", i), seg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := kv.Write(context.Background(), messageLogKey("k"), benchLogSize/segmentSize-1); err != nil {
+		b.Fatal(err)
+	}
+	return kv
+}
+
+func TestTruncateLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention Retention
+		committed int
+		wantStart int
+	}{
+		{
+			name:      "keep all",
+			retention: Retention{},
+			committed: 2500,
+			wantStart: 0,
+		},
+		{
+			name:      "max messages",
+			retention: Retention{MaxMessages: 1200},
+			wantStart: 1300,
+		},
+		{
+			name:      "committed",
+			retention: Retention{Committed: true},
+			committed: 2100,
+			wantStart: 2100,
+		},
+		{
+			name:      "max age",
+			retention: Retention{MaxAge: time.Hour},
+			wantStart: 2500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := utils.NewMemKV()
+			for i := 0; i < 2500; i++ {
+				if _, err := appendLog(kv, "k", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := registerGroup(kv, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := commitOffsets(kv, "", "", 0, map[string]int{"k": tt.committed}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.retention.MaxAge > 0 { // ages the messages
+				for i := 0; i < 3; i++ {
+					seg, _ := utils.ReadOrElse(kv, segmentKey("k", i), segment{})
+					seg.Last -= 2 * tt.retention.MaxAge.Milliseconds()
+					if err := kv.Write(context.Background(), segmentKey("k", i), seg); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if err := truncateLog(kv, "k", tt.retention); err != nil {
+				t.Fatalf("truncateLog failed: %v", err)
+			}
+			start, err := logStart(kv, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart {
+				t.Errorf("log start = %d, want %d", start, tt.wantStart)
+			}
+			for i := 0; i < 3; i++ {
+				seg, _ := utils.ReadOrElse(kv, segmentKey("k", i), segment{})
+				if wantTruncated := (i+1)*segmentSize <= start; seg.Truncated != wantTruncated {
+					t.Errorf("segment %d truncated = %v, want %v", i, seg.Truncated, wantTruncated)
+				}
+			}
+
+			pairs, err := readLog(kv, "k", start, math.MaxInt, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pairs) != 2500-start || len(pairs) > 0 && pairs[0] != [2]int{start, start} {
+				t.Errorf("read %d messages from %d, want %d", len(pairs), start, 2500-start)
+			}
+			if _, err := appendLog(kv, "k", 2500); err != nil {
+				t.Fatalf("appendLog after truncation failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAppendLogBatch(t *testing.T) {
+	kv := utils.NewMemKV()
+	if _, err := appendLogBatch(kv, "k", make([]message, segmentSize-10), ""); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]message, 25)
+	for i := range msgs {
+		msgs[i] = i
+	}
+	offsets, err := appendLogBatch(kv, "k", msgs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, offset := range offsets {
+		if offset != segmentSize-10+i {
+			t.Fatalf("offsets[%d] = %d, want %d", i, offset, segmentSize-10+i)
+		}
+	}
+	if !rolledOver(offsets[0], len(offsets)) {
+		t.Error("rolledOver = false, want true")
+	}
+
+	pairs, err := readLog(kv, "k", segmentSize-10, math.MaxInt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 25 || pairs[24] != [2]int{segmentSize + 14, 24} {
+		t.Errorf("read %v, want the batch across two segments", pairs)
+	}
+}
+
+func BenchmarkAppendLog(b *testing.B) {
+	kv := newBenchKV(b)
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := appendLog(kv, "k", i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
+
+func BenchmarkAppendLogBatch_100(b *testing.B) {
+	kv := newBenchKV(b)
+	msgs := make([]message, 100)
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := appendLogBatch(kv, "k", msgs, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N)/100, "kv-bytes/msg")
+}
+
+func BenchmarkReadLog_Tail(b *testing.B) {
+	kv := newBenchKV(b)
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pairs, err := readLog(kv, "k", benchLogSize-100, math.MaxInt, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(pairs) != 100 {
+			b.Fatalf("read %d messages, want 100", len(pairs))
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
+
+// The whole log as one value, as before segmenting, for comparison.
+func BenchmarkAppendLog_Unsegmented(b *testing.B) {
+	kv := utils.NewMemKV()
+	if err := kv.Write(context.Background(), "k", make([]message, benchLogSize)); err != nil {
+		b.Fatal(err)
+	}
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messageLog, err := utils.ReadOrElse(kv, "k", []message{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := kv.Write(context.Background(), "k", append(messageLog, i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
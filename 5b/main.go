@@ -1,24 +1,27 @@
 package main
 
 import (
-	"context"
 	"log"
+	"sync"
+	"time"
 
+	. "github.com/tobiajo/gossip-gloomers/common"
 	utils "github.com/tobiajo/gossip-gloomers/utils"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 type server struct {
-	kv *maelstrom.KV
+	kv         utils.KV
+	registered sync.Map // groups known to be registered
 }
 
-func messageLogKey(key string) string {
-	return "messageLog:" + key
-}
+type message = int
 
-func committedOffsetKey(key string) string {
-	return "committedOffset:" + key
+// retention keeps every message by default, as the Maelstrom checker expects polls to see the whole log.
+var retention = RetentionPolicy{
+	Default: Retention{},
+	Keys:    map[string]Retention{},
 }
 
 // Challenge #5b: Multi-Node Kafka-Style Log
@@ -30,9 +33,17 @@ func main() {
 	}
 
 	utils.RegisterHandler(n, "send", s.sendHandler)
+	utils.RegisterHandler(n, "send_batch", s.sendBatchHandler)
+	utils.RegisterHandler(n, "send_txn", s.sendTxnHandler)
+	utils.RegisterHandler(n, "send_record", s.sendRecordHandler)
 	utils.RegisterHandler(n, "poll", s.pollHandler)
+	utils.RegisterHandler(n, "poll_records", s.pollRecordsHandler)
+	utils.RegisterHandler(n, "offset_for_time", s.offsetForTimeHandler)
 	utils.RegisterHandler(n, "commit_offsets", s.commitOffsetsHandler)
 	utils.RegisterHandler(n, "list_committed_offsets", s.listCommittedOffsetsHandler)
+	utils.RegisterHandler(n, "join_group", s.joinGroupHandler)
+	utils.RegisterHandler(n, "heartbeat", s.heartbeatHandler)
+	utils.RegisterHandler(n, "leave_group", s.leaveGroupHandler)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -40,15 +51,14 @@ func main() {
 }
 
 func (s *server) sendHandler(req Send) (SendOk, error) {
-	messageLog, err := utils.ReadOrElse(s.kv, messageLogKey(req.Key), []int{})
+	offset, err := appendLog(s.kv, req.Key, req.Msg)
 	if err != nil {
 		return *new(SendOk), err
 	}
-	offset := len(messageLog)
-	updatedMessageLog := append(messageLog, req.Msg)
-	err = s.kv.CompareAndSwap(context.Background(), messageLogKey(req.Key), messageLog, updatedMessageLog, true)
-	if err != nil {
-		return *new(SendOk), err
+	if rolledOver(offset, 1) {
+		if err := truncateLog(s.kv, req.Key, retention.Of(req.Key)); err != nil {
+			log.Printf("error truncate %s: %v", req.Key, err) // retried on the next roll over or commit
+		}
 	}
 
 	res := SendOk{
@@ -57,30 +67,164 @@ func (s *server) sendHandler(req Send) (SendOk, error) {
 	return res, nil
 }
 
+func (s *server) sendBatchHandler(req SendBatch) (SendBatchOk, error) {
+	var mu sync.Mutex
+	offsets := make([]*int, len(req.Msgs))
+	errs := make(map[string]string)
+	var wg sync.WaitGroup
+	for key, indices := range batchByKey(req.Msgs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgs := make([]message, len(indices))
+			for i, j := range indices {
+				msgs[i] = req.Msgs[j].Msg
+			}
+			keyOffsets, err := appendLogBatch(s.kv, key, msgs, "")
+			if err == nil && rolledOver(keyOffsets[0], len(keyOffsets)) {
+				if err := truncateLog(s.kv, key, retention.Of(key)); err != nil {
+					log.Printf("error truncate %s: %v", key, err) // retried on the next roll over or commit
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err.Error()
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = &keyOffsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := SendBatchOk{
+		Offsets: offsets,
+		Errors:  errs,
+	}
+	return res, nil
+}
+
+func (s *server) sendRecordHandler(req SendRecord) (SendRecordOk, error) {
+	offset, timestamp, err := appendRecord(s.kv, req.Key, record{Value: req.Value, Headers: req.Headers})
+	if err != nil {
+		return *new(SendRecordOk), err
+	}
+	if rolledOver(offset, 1) {
+		if err := truncateLog(s.kv, req.Key, retention.Of(req.Key)); err != nil {
+			log.Printf("error truncate %s: %v", req.Key, err) // retried on the next roll over or commit
+		}
+	}
+
+	res := SendRecordOk{
+		Offset:    offset,
+		Timestamp: timestamp,
+	}
+	return res, nil
+}
+
+// batchByKey returns the indices of the messages per key, in order.
+func batchByKey(msgs []KeyedMsg) map[string][]int {
+	indices := make(map[string][]int)
+	for i, msg := range msgs {
+		indices[msg.Key] = append(indices[msg.Key], i)
+	}
+	return indices
+}
+
 func (s *server) pollHandler(req Poll) (PollOk, error) {
 	msgs := make(map[string][][2]int)
+	more := make(map[string]bool)
+	budget := req.PollLimits.Budget()
 	for key, offset := range req.Offsets {
-		messageLog, err := utils.ReadOrElse(s.kv, messageLogKey(key), []int{})
+		start, err := logStart(s.kv, key)
 		if err != nil {
 			return *new(PollOk), err
 		}
-		var pairs [][2]int
-		for i, message := range messageLog[offset:] {
-			pairs = append(pairs, [2]int{offset + i, message})
+		from, err := retention.PollFrom(key, offset, start)
+		if err != nil {
+			return *new(PollOk), err
+		}
+		pairs, err := readLog(s.kv, key, from, budget.Limit(), req.ReadCommitted)
+		if err != nil {
+			return *new(PollOk), err
+		}
+		pairs, more[key] = Take(budget, pairs)
+		if !more[key] {
+			delete(more, key)
 		}
 		msgs[key] = pairs
 	}
 
 	res := PollOk{
 		Msgs: msgs,
+		More: more,
 	}
 	return res, nil
 }
 
-func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error) {
+func (s *server) pollRecordsHandler(req PollRecords) (PollRecordsOk, error) {
+	records := make(map[string][]Record)
+	more := make(map[string]bool)
+	budget := req.PollLimits.Budget()
 	for key, offset := range req.Offsets {
-		err := s.kv.Write(context.Background(), committedOffsetKey(key), offset)
+		start, err := logStart(s.kv, key)
+		if err != nil {
+			return *new(PollRecordsOk), err
+		}
+		from, err := retention.PollFrom(key, offset, start)
+		if err != nil {
+			return *new(PollRecordsOk), err
+		}
+		keyRecords, err := readRecords(s.kv, key, from, budget.Limit(), req.ReadCommitted)
+		if err != nil {
+			return *new(PollRecordsOk), err
+		}
+		keyRecords, more[key] = Take(budget, keyRecords)
+		if !more[key] {
+			delete(more, key)
+		}
+		records[key] = keyRecords
+	}
+
+	res := PollRecordsOk{
+		Records: records,
+		More:    more,
+	}
+	return res, nil
+}
+
+func (s *server) offsetForTimeHandler(req OffsetForTime) (OffsetForTimeOk, error) {
+	offsets := make(map[string]int)
+	for key, timestamp := range req.Timestamps {
+		offset, err := offsetForTime(s.kv, key, timestamp)
 		if err != nil {
+			return *new(OffsetForTimeOk), err
+		}
+		offsets[key] = offset
+	}
+
+	res := OffsetForTimeOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error) {
+	if _, ok := s.registered.Load(req.Group); !ok {
+		if err := registerGroup(s.kv, req.Group); err != nil {
+			return *new(CommitOffsetsOk), err
+		}
+		s.registered.Store(req.Group, true)
+	}
+
+	if err := commitOffsets(s.kv, req.Group, req.Member, req.Generation, req.Offsets); err != nil {
+		return *new(CommitOffsetsOk), err
+	}
+	for key := range req.Offsets {
+		if err := truncateLog(s.kv, key, retention.Of(key)); err != nil {
 			return *new(CommitOffsetsOk), err
 		}
 	}
@@ -90,13 +234,13 @@ func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error
 }
 
 func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListCommittedOffsetsOk, error) {
+	g, err := utils.ReadOrElse(s.kv, groupKey(req.Group), newGroupRecord())
+	if err != nil {
+		return *new(ListCommittedOffsetsOk), err
+	}
 	offsets := make(map[string]int)
 	for _, key := range req.Keys {
-		committedOffset, err := utils.ReadOrElse(s.kv, committedOffsetKey(key), 0)
-		if err != nil {
-			return *new(ListCommittedOffsetsOk), err
-		}
-		offsets[key] = committedOffset
+		offsets[key] = g.Committed[key]
 	}
 
 	res := ListCommittedOffsetsOk{
@@ -104,3 +248,50 @@ func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListComm
 	}
 	return res, nil
 }
+
+func (s *server) joinGroupHandler(req JoinGroup) (JoinGroupOk, error) {
+	g, err := updateGroup(s.kv, req.Group, func(g *groupRecord) error {
+		g.Join(req.Member, req.Keys, time.Now())
+		return nil
+	})
+	if err != nil {
+		return *new(JoinGroupOk), err
+	}
+
+	res := JoinGroupOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) heartbeatHandler(req Heartbeat) (HeartbeatOk, error) {
+	g, err := updateGroup(s.kv, req.Group, func(g *groupRecord) error {
+		if !g.Heartbeat(req.Member, time.Now()) {
+			return UnknownMemberError(req.Group, req.Member)
+		}
+		return nil
+	})
+	if err != nil {
+		return *new(HeartbeatOk), err
+	}
+
+	res := HeartbeatOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) leaveGroupHandler(req LeaveGroup) (LeaveGroupOk, error) {
+	_, err := updateGroup(s.kv, req.Group, func(g *groupRecord) error {
+		g.Leave(req.Member)
+		return nil
+	})
+	if err != nil {
+		return *new(LeaveGroupOk), err
+	}
+
+	res := LeaveGroupOk{}
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+func TestRecords(t *testing.T) {
+	kv := utils.NewMemKV()
+	if _, err := appendLog(kv, "k", 7); err != nil {
+		t.Fatal(err)
+	}
+	offset, timestamp, err := appendRecord(kv, "k", record{Value: json.RawMessage(`{"a":1}`), Headers: map[string]string{"h": "v"}})
+	if err != nil || offset != 1 {
+		t.Fatalf("appendRecord = %d, %v, want offset 1", offset, err)
+	}
+	if _, err := appendLog(kv, "k", 8); err != nil {
+		t.Fatal(err)
+	}
+
+	pairs, err := readLog(kv, "k", 0, math.MaxInt, false)
+	if want := [][2]int{{0, 7}, {2, 8}}; err != nil || !slices.Equal(pairs, want) {
+		t.Errorf("readLog = %v, %v, want %v leaving out the record", pairs, err, want)
+	}
+	records, err := readRecords(kv, "k", 0, math.MaxInt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || string(records[0].Value) != "7" || string(records[1].Value) != `{"a":1}` ||
+		records[1].Headers["h"] != "v" || records[1].Timestamp != timestamp || string(records[2].Value) != "8" {
+		t.Errorf("readRecords = %+v, want the messages and the record", records)
+	}
+}
+
+func TestOffsetForTime(t *testing.T) {
+	kv := utils.NewMemKV()
+	timestamps := make([]int64, segmentSize-1) // the last one kept as Last
+	for i := range timestamps {
+		timestamps[i] = 200
+	}
+	segments := []segment{
+		{Msgs: make([]message, segmentSize), Last: 100}, // appended before the timestamps were kept
+		{Msgs: make([]message, segmentSize), Timestamps: timestamps, Last: 300},
+		{Msgs: make([]message, 2), Timestamps: []int64{300, 400}, Last: 400},
+	}
+	for i, seg := range segments {
+		if err := kv.Write(context.Background(), segmentKey("k", i), seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Write(context.Background(), messageLogKey("k"), len(segments)-1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		timestamp int64
+		want      int
+	}{
+		{0, 0},
+		{100, 0},
+		{101, segmentSize},
+		{250, 2*segmentSize - 1},
+		{300, 2*segmentSize - 1},
+		{301, 2*segmentSize + 1},
+		{401, 2*segmentSize + 2},
+	}
+	for _, tt := range tests {
+		if got, err := offsetForTime(kv, "k", tt.timestamp); err != nil || got != tt.want {
+			t.Errorf("offsetForTime(%d) = %d, %v, want %d", tt.timestamp, got, err, tt.want)
+		}
+	}
+}
+
+func TestOffsetForTime_EmptyTail(t *testing.T) {
+	kv := utils.NewMemKV()
+	seg := segment{Msgs: make([]message, segmentSize), Last: 100}
+	if err := kv.Write(context.Background(), segmentKey("k", 0), seg); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Write(context.Background(), messageLogKey("k"), 1); err != nil { // not yet appended to
+		t.Fatal(err)
+	}
+
+	for timestamp, want := range map[int64]int{0: 0, 100: 0, 101: segmentSize} {
+		if got, err := offsetForTime(kv, "k", timestamp); err != nil || got != want {
+			t.Errorf("offsetForTime(%d) = %d, %v, want %d", timestamp, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	"github.com/google/uuid"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	txnOpen      = "open"
+	txnCommitted = "committed"
+	txnAborted   = "aborted"
+	txnTimeout   = 5 * time.Second // before a reader aborts a transaction left open
+)
+
+// A transaction appends its messages to each of its keys tagged with its id, then decides its outcome with a CAS of its
+// status under txn:<id> in the lin-kv, as the marker of Kafka's transactions. With no owner per key, the status is the
+// single marker of the transaction, so the coordinator and a reader aborting a transaction of a failed coordinator agree
+// on it: the first CAS from open wins.
+// https://www.confluent.io/blog/transactions-apache-kafka/
+
+type txnStatus struct {
+	State   string `json:"state"`
+	Started int64  `json:"started"` // unix millis
+}
+
+func txnKey(txn string) string {
+	return "txn:" + txn
+}
+
+// sendTxnHandler appends the messages to their keys, all committed or all aborted.
+func (s *server) sendTxnHandler(req SendTxn) (SendTxnOk, error) {
+	if len(req.Msgs) == 0 {
+		return SendTxnOk{Offsets: []int{}}, nil
+	}
+	txn := uuid.NewString()
+	open := txnStatus{State: txnOpen, Started: time.Now().UnixMilli()}
+	if err := s.kv.Write(context.Background(), txnKey(txn), open); err != nil {
+		return *new(SendTxnOk), err
+	}
+
+	var mu sync.Mutex
+	offsets := make([]int, len(req.Msgs))
+	var appendErr error
+	var wg sync.WaitGroup
+	for key, indices := range batchByKey(req.Msgs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgs := make([]message, len(indices))
+			for i, j := range indices {
+				msgs[i] = req.Msgs[j].Msg
+			}
+			keyOffsets, err := appendLogBatch(s.kv, key, msgs, txn)
+			if err == nil && rolledOver(keyOffsets[0], len(keyOffsets)) {
+				if err := truncateLog(s.kv, key, retention.Of(key)); err != nil {
+					log.Printf("error truncate %s: %v", key, err) // retried on the next roll over or commit
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if appendErr == nil {
+					appendErr = err
+				}
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = keyOffsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	decided := txnStatus{State: txnCommitted, Started: open.Started}
+	if appendErr != nil {
+		decided.State = txnAborted
+	}
+	err := s.kv.CompareAndSwap(context.Background(), txnKey(txn), open, decided, false)
+	if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		appendErr = errors.New("aborted by a reader as left open")
+	} else if err != nil {
+		return *new(SendTxnOk), err // left open, aborted by a reader once expired
+	}
+	if appendErr != nil {
+		return *new(SendTxnOk), maelstrom.NewRPCError(maelstrom.TxnConflict, fmt.Sprintf("%s aborted: %v", txn, appendErr))
+	}
+
+	return SendTxnOk{Offsets: offsets}, nil
+}
+
+// txnOutcome returns the state of the transaction, aborting it if left open for longer than txnTimeout.
+func txnOutcome(kv utils.KV, txn string) (string, error) {
+	for {
+		status, err := utils.ReadOrElse(kv, txnKey(txn), txnStatus{State: txnOpen, Started: time.Now().UnixMilli()})
+		if err != nil {
+			return "", err
+		}
+		if status.State != txnOpen || time.Since(time.UnixMilli(status.Started)) <= txnTimeout {
+			return status.State, nil
+		}
+		aborted := txnStatus{State: txnAborted, Started: status.Started}
+		err = kv.CompareAndSwap(context.Background(), txnKey(txn), status, aborted, false)
+		if err == nil {
+			return txnAborted, nil
+		}
+		if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed { // else decided meanwhile
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+func TestSendTxn(t *testing.T) {
+	kv := utils.NewMemKV()
+	s := server{kv: kv}
+	res, err := s.sendTxnHandler(SendTxn{Msgs: []KeyedMsg{{Key: "a", Msg: 1}, {Key: "b", Msg: 2}, {Key: "a", Msg: 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 0, 1}; !slices.Equal(res.Offsets, want) {
+		t.Errorf("offsets = %v, want %v", res.Offsets, want)
+	}
+	for key, want := range map[string][][2]int{"a": {{0, 1}, {1, 3}}, "b": {{0, 2}}} {
+		if pairs, err := readLog(kv, key, 0, math.MaxInt, true); err != nil || !slices.Equal(pairs, want) {
+			t.Errorf("read committed %s = %v, %v, want %v", key, pairs, err, want)
+		}
+	}
+}
+
+func TestReadLog_ReadCommitted(t *testing.T) {
+	kv := utils.NewMemKV()
+	now := time.Now().UnixMilli()
+	statuses := map[string]txnStatus{
+		"t1": {State: txnCommitted, Started: now},
+		"t2": {State: txnAborted, Started: now},
+		"t3": {State: txnOpen, Started: now},
+	}
+	for txn, status := range statuses {
+		if err := kv.Write(context.Background(), txnKey(txn), status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, txn := range []string{"", "t1", "t2", "t3", ""} {
+		if _, err := appendLogBatch(kv, "k", []message{i}, txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		readCommitted bool
+		want          [][2]int
+	}{
+		{
+			name: "read uncommitted",
+			want: [][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}},
+		},
+		{
+			name:          "read committed",
+			readCommitted: true,
+			want:          [][2]int{{0, 0}, {1, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs, err := readLog(kv, "k", 0, math.MaxInt, tt.readCommitted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(pairs, tt.want) {
+				t.Errorf("readLog = %v, want %v", pairs, tt.want)
+			}
+		})
+	}
+
+	expired := txnStatus{State: txnOpen, Started: time.Now().Add(-2 * txnTimeout).UnixMilli()}
+	if err := kv.Write(context.Background(), txnKey("t3"), expired); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]int{{0, 0}, {1, 1}, {4, 4}}
+	if pairs, err := readLog(kv, "k", 0, math.MaxInt, true); err != nil || !slices.Equal(pairs, want) {
+		t.Errorf("read committed past an expired transaction = %v, %v, want %v", pairs, err, want)
+	}
+	if state, err := txnOutcome(kv, "t3"); err != nil || state != txnAborted {
+		t.Errorf("outcome of the expired transaction = %s, %v, want %s", state, err, txnAborted)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const groupsKey = "consumerGroups" // names of the groups with committed offsets, the default group is ""
+
+func groupKey(group string) string {
+	return "consumerGroup:" + strconv.Quote(group)
+}
+
+func committedOffsetKey(group, key string) string {
+	return "committedOffset:" + strconv.Quote(group) + ":" + key
+}
+
+// updateGroup applies the update to the group with the expired members removed, as the single writer of the group,
+// caller must hold the lock of the group.
+func updateGroup(kv utils.KV, group string, update func(g *ConsumerGroup) error) (ConsumerGroup, error) {
+	g, err := readGroup(kv, group)
+	if err != nil {
+		return *new(ConsumerGroup), err
+	}
+	if err := update(&g); err != nil {
+		return *new(ConsumerGroup), err
+	}
+	if err := kv.Write(context.Background(), groupKey(group), g); err != nil {
+		return *new(ConsumerGroup), err
+	}
+	return g, nil
+}
+
+func readGroup(kv utils.KV, group string) (ConsumerGroup, error) {
+	g, err := utils.ReadOrElse(kv, groupKey(group), NewConsumerGroup())
+	if err != nil {
+		return *new(ConsumerGroup), err
+	}
+	g.Expire(time.Now())
+	return g, nil
+}
+
+// registerGroup adds the group to the groups with committed offsets, if absent.
+func registerGroup(kv utils.KV, group string) error {
+	for {
+		groups, err := utils.ReadOrElse(kv, groupsKey, []string{})
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if g == group {
+				return nil
+			}
+		}
+		err = kv.CompareAndSwap(context.Background(), groupsKey, groups, append(groups, group), true)
+		if err == nil || maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			return err
+		}
+	}
+}
+
+// minCommittedOffset returns the offset of the key committed by every group. The reads may be stale, so the offsets of
+// a group registered concurrently may be missed.
+func minCommittedOffset(kv utils.KV, key string) (int, error) {
+	groups, err := utils.ReadOrElse(kv, groupsKey, []string{})
+	if err != nil {
+		return *new(int), err
+	}
+	committed := -1
+	for _, group := range groups {
+		offset, err := utils.ReadOrElse(kv, committedOffsetKey(group, key), 0)
+		if err != nil {
+			return *new(int), err
+		}
+		if committed == -1 || offset < committed {
+			committed = offset
+		}
+	}
+	return max(committed, 0), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+const segmentSize = 1000 // messages per segment
+
+// The log of a key is split into fixed-size segments under messageLog:<key>:<segment>, the head pointer under
+// messageLog:<key> is the index of the tail segment. An append only touches the tail segment, so it costs O(segment)
+// rather than O(log), and the offset of a message follows from its segment and position in it.
+//
+// Retention moves the log start under messageLog:<key>:start forward and overwrites the segments wholly before it with
+// tombstones, as the KV has no delete. Retention is applied by the owner of the key when the tail segment rolls over.
+
+type segment struct {
+	Msgs       []message             `json:"msgs"`
+	Timestamps []int64               `json:"timestamps,omitempty"` // unix millis per message, never decreasing
+	Records    map[int]record        `json:"records,omitempty"`    // by offset, the messages at these are placeholders
+	Last       int64                 `json:"last"`                 // unix millis of the last append
+	Truncated  bool                  `json:"truncated,omitempty"`  // dropped by retention
+	Producers  map[string][]produced `json:"producers,omitempty"`  // as of the last append, see ownedLog
+	Txns       map[int]txnTag        `json:"txns,omitempty"`       // tags of the messages appended by transactions
+}
+
+// record is a message with an arbitrary JSON value.
+type record struct {
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (s segment) full() bool {
+	return s.Truncated || len(s.Msgs) >= segmentSize
+}
+
+// timestamps returns the timestamp per message, those appended before the timestamps were kept having the last one.
+func (s segment) timestamps() []int64 {
+	timestamps := slices.Clone(s.Timestamps)
+	for len(timestamps) < len(s.Msgs) {
+		timestamps = append(timestamps, s.Last)
+	}
+	return timestamps
+}
+
+func messageLogKey(key string) string {
+	return "messageLog:" + key
+}
+
+func segmentKey(key string, segment int) string {
+	return messageLogKey(key) + ":" + strconv.Itoa(segment)
+}
+
+func logStartKey(key string) string {
+	return messageLogKey(key) + ":start"
+}
+
+// rolledOver tells if appending count messages from the offset started a new segment.
+func rolledOver(offset, count int) bool {
+	return (offset+count-1)/segmentSize > max(offset-1, 0)/segmentSize
+}
+
+func logStart(kv utils.KV, key string) (int, error) {
+	return utils.ReadOrElse(kv, logStartKey(key), 0)
+}
+
+// truncateLog moves the log start forward as far as the retention allows and tombstones the dropped segments, as the
+// single writer of the key, caller must hold the lock of the key.
+func truncateLog(kv utils.KV, key string, r Retention) error {
+	if r == (Retention{}) {
+		return nil
+	}
+	start, err := logStart(kv, key)
+	if err != nil {
+		return err
+	}
+	tail, err := utils.ReadOrElse(kv, messageLogKey(key), 0)
+	if err != nil {
+		return err
+	}
+	committed := 0
+	if r.Committed {
+		committed, err = minCommittedOffset(kv, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	seg, err := utils.ReadOrElse(kv, segmentKey(key, tail), segment{})
+	if err != nil {
+		return err
+	}
+	end := tail*segmentSize + len(seg.Msgs)
+
+	// the start of the first segment with a message younger than MaxAge
+	unexpired := end
+	if r.MaxAge > 0 {
+		cutoff := time.Now().Add(-r.MaxAge).UnixMilli()
+		if seg.Last > cutoff {
+			unexpired = tail * segmentSize
+		}
+		for i := start / segmentSize; i < tail; i++ {
+			seg, err := utils.ReadOrElse(kv, segmentKey(key, i), segment{})
+			if err != nil {
+				return err
+			}
+			if !seg.Truncated && seg.Last > cutoff {
+				unexpired = i * segmentSize
+				break
+			}
+		}
+	}
+
+	truncated := r.Start(start, end, committed, unexpired)
+	if truncated <= start {
+		return nil
+	}
+	if err := kv.Write(context.Background(), logStartKey(key), truncated); err != nil {
+		return err
+	}
+	return tombstone(kv, key, start, truncated)
+}
+
+// tombstone overwrites the segments wholly within [from, to), these are full, so no append writes them again.
+func tombstone(kv utils.KV, key string, from, to int) error {
+	for i := from / segmentSize; i < to/segmentSize; i++ {
+		err := kv.Write(context.Background(), segmentKey(key, i), segment{Truncated: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+const benchLogSize = 100_000
+
+func newBenchKV(b *testing.B) *utils.MemKV {
+	kv := utils.NewMemKV()
+	for i := 0; i < benchLogSize/segmentSize; i++ {
+		seg := segment{Msgs: make([]message, segmentSize)}
+		if err := kv.Write(context.Background(), segmentKey("k", i), seg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := kv.Write(context.Background(), messageLogKey("k"), benchLogSize/segmentSize-1); err != nil {
+		b.Fatal(err)
+	}
+	return kv
+}
+
+func TestTruncateLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention Retention
+		committed int
+		wantStart int
+	}{
+		{
+			name:      "keep all",
+			retention: Retention{},
+			committed: 2500,
+			wantStart: 0,
+		},
+		{
+			name:      "max messages",
+			retention: Retention{MaxMessages: 1200},
+			wantStart: 1300,
+		},
+		{
+			name:      "committed",
+			retention: Retention{Committed: true},
+			committed: 2100,
+			wantStart: 2100,
+		},
+		{
+			name:      "max age",
+			retention: Retention{MaxAge: time.Hour},
+			wantStart: 2500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := utils.NewMemKV()
+			l, err := loadLog(kv, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2500; i++ {
+				if _, err := l.append(kv, "k", KeyedMsg{Msg: i}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := registerGroup(kv, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := kv.Write(context.Background(), committedOffsetKey("", "k"), tt.committed); err != nil {
+				t.Fatal(err)
+			}
+			if tt.retention.MaxAge > 0 { // ages the messages
+				for i := 0; i < 3; i++ {
+					seg, _ := utils.ReadOrElse(kv, segmentKey("k", i), segment{})
+					seg.Last -= 2 * tt.retention.MaxAge.Milliseconds()
+					if err := kv.Write(context.Background(), segmentKey("k", i), seg); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if err := l.truncate(kv, "k", tt.retention); err != nil {
+				t.Fatalf("truncate failed: %v", err)
+			}
+			start, err := logStart(kv, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart || l.start != tt.wantStart {
+				t.Errorf("log start = %d, want %d", start, tt.wantStart)
+			}
+			for i := 0; i < 3; i++ {
+				seg, _ := utils.ReadOrElse(kv, segmentKey("k", i), segment{})
+				if wantTruncated := (i+1)*segmentSize <= start; seg.Truncated != wantTruncated {
+					t.Errorf("segment %d truncated = %v, want %v", i, seg.Truncated, wantTruncated)
+				}
+			}
+
+			loaded, err := loadLog(kv, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pairs := loaded.read(start, math.MaxInt, false)
+			if len(pairs) != 2500-start || len(pairs) > 0 && pairs[0] != [2]int{start, start} {
+				t.Errorf("read %d messages from %d, want %d", len(pairs), start, 2500-start)
+			}
+			if got := l.read(start, math.MaxInt, false); !slices.Equal(got, pairs) {
+				t.Errorf("read %d messages from memory, want the %d loaded from the KV", len(got), len(pairs))
+			}
+			if _, err := l.append(kv, "k", KeyedMsg{Msg: 2500}); err != nil {
+				t.Fatalf("append after truncation failed: %v", err)
+			}
+			reloaded, err := loadLog(kv, "k") // as written through
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(reloaded.read(0, math.MaxInt, false), l.read(0, math.MaxInt, false)) {
+				t.Error("reloaded log differs from the log in memory")
+			}
+		})
+	}
+}
+
+func TestOwnedLog_AppendBatch(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.appendBatch(kv, "k", make([]KeyedMsg, segmentSize-10), txnTag{}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]KeyedMsg, 2*segmentSize)
+	for i := range msgs {
+		msgs[i] = KeyedMsg{Msg: i}
+	}
+	offsets, err := l.appendBatch(kv, "k", msgs, txnTag{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets[0] != segmentSize-10 || offsets[len(offsets)-1] != 3*segmentSize-11 {
+		t.Errorf("offsets from %d to %d, want from %d to %d", offsets[0], offsets[len(offsets)-1], segmentSize-10, 3*segmentSize-11)
+	}
+	if !rolledOver(offsets[0], len(offsets)) || rolledOver(1, segmentSize-1) {
+		t.Error("rolledOver wrong")
+	}
+
+	reloaded, err := loadLog(kv, "k") // as written through
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(reloaded.read(0, math.MaxInt, false), l.read(0, math.MaxInt, false)) {
+		t.Error("reloaded log differs from the log in memory")
+	}
+}
+
+func TestOwnedLog_Idempotent(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.appendBatch(kv, "k", make([]KeyedMsg, segmentSize-1), txnTag{}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []KeyedMsg{
+		{Msg: 1, Producer: "p", Seq: 1},
+		{Msg: 2, Producer: "p", Seq: 2},
+		{Msg: 1, Producer: "p", Seq: 1}, // retried within the batch
+		{Msg: 1, Producer: "q", Seq: 1},
+	}
+	offsets, err := l.appendBatch(kv, "k", msgs, txnTag{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{segmentSize - 1, segmentSize, segmentSize - 1, segmentSize + 1}
+	if !slices.Equal(offsets, want) {
+		t.Errorf("offsets = %v, want %v", offsets, want)
+	}
+
+	reloaded, err := loadLog(kv, "k") // as written through, across the roll over
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := reloaded.append(kv, "k", KeyedMsg{Msg: 2, Producer: "p", Seq: 2})
+	if err != nil || offset != segmentSize || reloaded.end() != segmentSize+2 {
+		t.Errorf("retried send = %d, %v, end %d, want offset %d without an append", offset, err, reloaded.end(), segmentSize)
+	}
+
+	for seq := 3; seq < 3+producerWindow; seq++ {
+		if _, err := reloaded.append(kv, "k", KeyedMsg{Msg: seq, Producer: "p", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reloaded.append(kv, "k", KeyedMsg{Msg: 2, Producer: "p", Seq: 2}); err == nil {
+		t.Error("send of a forgotten sequence number succeeded, want rejected")
+	}
+}
+
+func TestOwnedLog_OutOfOrder(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []int{2, 1, 2, 1} { // forwarded concurrently, then retried
+		if _, err := l.append(kv, "k", KeyedMsg{Msg: seq, Producer: "p", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := l.read(0, math.MaxInt, false), [][2]int{{0, 2}, {1, 1}}; !slices.Equal(got, want) {
+		t.Errorf("read = %v, want each sequence number appended once %v", got, want)
+	}
+
+	for seq := 4; seq < 4+producerWindow; seq++ {
+		if _, err := l.append(kv, "k", KeyedMsg{Msg: seq, Producer: "p", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.append(kv, "k", KeyedMsg{Msg: 3, Producer: "p", Seq: 3}); err == nil {
+		t.Error("send older than the window succeeded, want rejected")
+	}
+}
+
+func BenchmarkAppendLog(b *testing.B) {
+	kv := newBenchKV(b)
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		b.Fatal(err)
+	}
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := l.append(kv, "k", KeyedMsg{Msg: i}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
+
+func BenchmarkOwnedLog_ReadTail(b *testing.B) {
+	kv := newBenchKV(b)
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		b.Fatal(err)
+	}
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if pairs := l.read(benchLogSize-100, math.MaxInt, false); len(pairs) != 100 {
+			b.Fatalf("read %d messages, want 100", len(pairs))
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
+
+// The whole log as one value, as before segmenting, for comparison.
+func BenchmarkAppendLog_Unsegmented(b *testing.B) {
+	kv := utils.NewMemKV()
+	if err := kv.Write(context.Background(), "k", make([]message, benchLogSize)); err != nil {
+		b.Fatal(err)
+	}
+	start := kv.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messageLog, err := utils.ReadOrElse(kv, "k", []message{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := kv.Write(context.Background(), "k", append(messageLog, i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(kv.Bytes()-start)/float64(b.N), "kv-bytes/op")
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"hash/fnv"
 	"log"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	. "github.com/tobiajo/gossip-gloomers/common"
 	utils "github.com/tobiajo/gossip-gloomers/utils"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
@@ -14,17 +18,24 @@ import (
 )
 
 type server struct {
-	n   *maelstrom.Node
-	kv  *maelstrom.KV
-	mus cmap.ConcurrentMap[string, *sync.Mutex]
+	n          *maelstrom.Node
+	kv         utils.KV
+	mus        cmap.ConcurrentMap[string, *sync.Mutex]
+	logs       cmap.ConcurrentMap[string, *ownedLog] // of the keys this node owns
+	registered sync.Map                              // groups known to be registered
+	run        string                                // distinguishes the producer id of this run from earlier ones
+	forwards   atomic.Int64                          // sequence number of the sends this node forwards
+	txns       atomic.Int64                          // sequence number of the transactions this node coordinates
 }
 
-func messageLogKey(key string) string {
-	return "messageLog:" + key
-}
+type message = int
+
+const forwardTimeout = time.Second // group requests are forwarded without retries, so a failure reaches the client
 
-func committedOffsetKey(key string) string {
-	return "committedOffset:" + key
+// retention keeps every message by default, as the Maelstrom checker expects polls to see the whole log.
+var retention = RetentionPolicy{
+	Default: Retention{},
+	Keys:    map[string]Retention{},
 }
 
 func keyToNodeID(key string, nodes int) string {
@@ -38,15 +49,36 @@ func keyToNodeID(key string, nodes int) string {
 func main() {
 	n := maelstrom.NewNode()
 	s := server{
-		n:   n,
-		kv:  maelstrom.NewSeqKV(n), // safe with single writer for a key, assuming OK to have eventual consistent reads on non-writer nodes
-		mus: cmap.New[*sync.Mutex](),
+		n:    n,
+		kv:   maelstrom.NewSeqKV(n), // safe with single writer for a key, assuming OK to have eventual consistent reads on non-writer nodes
+		mus:  cmap.New[*sync.Mutex](),
+		logs: cmap.New[*ownedLog](),
+		run:  strconv.FormatInt(time.Now().UnixNano(), 36),
 	}
 
+	go func() {
+		for range time.Tick(time.Second) {
+			s.resolveExpired()
+		}
+	}()
+
+	// external
 	utils.RegisterHandler(n, "send", s.sendHandler)
+	utils.RegisterHandler(n, "send_batch", s.sendBatchHandler)
+	utils.RegisterHandler(n, "send_txn", s.sendTxnHandler)
+	utils.RegisterHandler(n, "send_record", s.sendRecordHandler)
 	utils.RegisterHandler(n, "poll", s.pollHandler)
+	utils.RegisterHandler(n, "poll_records", s.pollRecordsHandler)
+	utils.RegisterHandler(n, "offset_for_time", s.offsetForTimeHandler)
 	utils.RegisterHandler(n, "commit_offsets", s.commitOffsetsHandler)
 	utils.RegisterHandler(n, "list_committed_offsets", s.listCommittedOffsetsHandler)
+	utils.RegisterHandler(n, "join_group", s.joinGroupHandler)
+	utils.RegisterHandler(n, "heartbeat", s.heartbeatHandler)
+	utils.RegisterHandler(n, "leave_group", s.leaveGroupHandler)
+
+	// internal
+	utils.RegisterHandler(n, "txn_append", s.txnAppendHandler)
+	utils.RegisterHandler(n, "txn_end", s.txnEndHandler)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -57,54 +89,408 @@ func (s *server) sendHandler(req Send) (SendOk, error) {
 	key := messageLogKey(req.Key)
 	dest := keyToNodeID(key, len(s.n.NodeIDs()))
 	if dest != s.n.ID() {
-		return utils.Send[Send, SendOk](s.n, "send", dest, req)
+		return s.forwardSend(dest, req)
 	}
 
-	s.mus.SetIfAbsent(key, new(sync.Mutex))
-	mu, _ := s.mus.Get(key)
+	mu := s.mutex(key)
 	mu.Lock()
 	defer mu.Unlock()
-	messageLog, err := utils.ReadOrElse(s.kv, key, []int{})
+	l, err := s.ownedLog(req.Key)
 	if err != nil {
 		return *new(SendOk), err
 	}
-	updatedMessageLog := append(messageLog, req.Msg)
-	err = s.kv.Write(context.Background(), key, updatedMessageLog)
+	end := l.end()
+	offset, err := l.append(s.kv, req.Key, KeyedMsg{Key: req.Key, Msg: req.Msg, Producer: req.Producer, Seq: req.Seq})
 	if err != nil {
 		return *new(SendOk), err
 	}
+	if l.end() > end && rolledOver(end, l.end()-end) {
+		if err := l.truncate(s.kv, req.Key, retention.Of(req.Key)); err != nil {
+			log.Printf("error truncate %s: %v", req.Key, err) // retried on the next roll over
+		}
+	}
 
 	res := SendOk{
-		Offset: len(updatedMessageLog) - 1,
+		Offset: offset,
 	}
 	return res, nil
 }
 
-func (s *server) pollHandler(req Poll) (PollOk, error) {
-	msgs := make(map[string][][2]int)
-	for key, offset := range req.Offsets {
-		messageLog, err := utils.ReadOrElse(s.kv, messageLogKey(key), []int{})
-		if err != nil {
-			return *new(PollOk), err
+// sendRecordHandler appends the record at the owner of the key. Forwarded without retries, as a retry of a record whose
+// original was appended would append it again.
+func (s *server) sendRecordHandler(req SendRecord) (SendRecordOk, error) {
+	key := messageLogKey(req.Key)
+	dest := keyToNodeID(key, len(s.n.NodeIDs()))
+	if dest != s.n.ID() {
+		return forward[SendRecord, SendRecordOk](s.n, "send_record", dest, req)
+	}
+
+	mu := s.mutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+	l, err := s.ownedLog(req.Key)
+	if err != nil {
+		return *new(SendRecordOk), err
+	}
+	offset, timestamp, err := l.appendRecord(s.kv, req.Key, record{Value: req.Value, Headers: req.Headers})
+	if err != nil {
+		return *new(SendRecordOk), err
+	}
+	if rolledOver(offset, 1) {
+		if err := l.truncate(s.kv, req.Key, retention.Of(req.Key)); err != nil {
+			log.Printf("error truncate %s: %v", req.Key, err) // retried on the next roll over
 		}
-		var pairs [][2]int
-		if len(messageLog) > offset {
-			for i, message := range messageLog[offset:] {
-				pairs = append(pairs, [2]int{offset + i, message})
+	}
+
+	res := SendRecordOk{
+		Offset:    offset,
+		Timestamp: timestamp,
+	}
+	return res, nil
+}
+
+// forwardSend forwards the send to the owner of the key, retrying until acknowledged. Unless sent by a producer of its
+// own, the send is stamped with this node as producer, so a retry whose original was appended gets back its offset
+// rather than being appended again. Concurrent forwards may reach the owner out of order, within its producer window.
+func (s *server) forwardSend(dest string, req Send) (SendOk, error) {
+	if req.Producer == "" {
+		req.Producer = s.n.ID() + "-" + s.run
+		req.Seq = int(s.forwards.Add(1))
+	}
+	return utils.Send[Send, SendOk](s.n, "send", dest, req)
+}
+
+// sendBatchHandler appends the messages of the keys this node owns and forwards the others, one request per owner.
+func (s *server) sendBatchHandler(req SendBatch) (SendBatchOk, error) {
+	byKey := batchByKey(req.Msgs)
+	forwards := make(map[string]SendBatch)
+	local := make(map[string][]int)
+	for key, indices := range byKey {
+		dest := keyToNodeID(messageLogKey(key), len(s.n.NodeIDs()))
+		if dest == s.n.ID() {
+			local[key] = indices
+			continue
+		}
+		forwarded := forwards[dest]
+		for _, j := range indices {
+			forwarded.Msgs = append(forwarded.Msgs, req.Msgs[j])
+		}
+		forwards[dest] = forwarded
+	}
+
+	var mu sync.Mutex
+	offsets := make([]*int, len(req.Msgs))
+	errs := make(map[string]string)
+	var wg sync.WaitGroup
+	for dest, forwarded := range forwards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := forward[SendBatch, SendBatchOk](s.n, "send_batch", dest, forwarded)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, msg := range forwarded.Msgs {
+					errs[msg.Key] = err.Error()
+				}
+				return
+			}
+			for key, keyErr := range res.Errors {
+				errs[key] = keyErr
+			}
+			for key, indices := range batchByKey(forwarded.Msgs) { // the forwarded messages keep their order per key
+				for i, j := range byKey[key] {
+					offsets[j] = res.Offsets[indices[i]]
+				}
+			}
+		}()
+	}
+	for key, indices := range local {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keyMsgs := make([]KeyedMsg, len(indices))
+			for i, j := range indices {
+				keyMsgs[i] = req.Msgs[j]
 			}
+			keyOffsets, err := s.appendOwned(key, keyMsgs, txnTag{})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err.Error()
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = &keyOffsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := SendBatchOk{
+		Offsets: offsets,
+		Errors:  errs,
+	}
+	return res, nil
+}
+
+// appendOwned appends the messages to a key this node owns, tagged with the transaction if any, unless it already
+// ended in the key.
+func (s *server) appendOwned(key string, msgs []KeyedMsg, txn txnTag) ([]int, error) {
+	mu := s.mutex(messageLogKey(key))
+	mu.Lock()
+	defer mu.Unlock()
+	l, err := s.ownedLog(key)
+	if err != nil {
+		return nil, err
+	}
+	if txn.Txn != "" {
+		if _, ended, err := l.marker(s.kv, key, txn.Txn); err != nil {
+			return nil, err
+		} else if ended {
+			return nil, maelstrom.NewRPCError(maelstrom.TxnConflict, txn.Txn+" already ended")
+		}
+	}
+	end := l.end()
+	offsets, err := l.appendBatch(s.kv, key, msgs, txn)
+	if err != nil {
+		return nil, err
+	}
+	if l.end() > end && rolledOver(end, l.end()-end) {
+		if err := l.truncate(s.kv, key, retention.Of(key)); err != nil {
+			log.Printf("error truncate %s: %v", key, err) // retried on the next roll over
 		}
-		msgs[key] = pairs
+	}
+	return offsets, nil
+}
+
+// batchByKey returns the indices of the messages per key, in order.
+func batchByKey(msgs []KeyedMsg) map[string][]int {
+	indices := make(map[string][]int)
+	for i, msg := range msgs {
+		indices[msg.Key] = append(indices[msg.Key], i)
+	}
+	return indices
+}
+
+// pollHandler serves the keys this node owns from memory and forwards the others to their owners, so a poll never
+// reads a stale log from the seq-kv.
+func (s *server) pollHandler(req Poll) (PollOk, error) {
+	read := func(l *ownedLog, from int, limit int) [][2]int {
+		return l.read(from, limit, req.ReadCommitted)
+	}
+	remote := func(dest string, offsets map[string]int) (map[string][][2]int, map[string]bool, error) {
+		forwarded := Poll{Offsets: offsets, ReadCommitted: req.ReadCommitted, PollLimits: req.PollLimits}
+		res, err := forward[Poll, PollOk](s.n, "poll", dest, forwarded)
+		return res.Msgs, res.More, err
+	}
+	msgs, more, err := pollKeys(s, req.Offsets, req.PollLimits, read, remote)
+	if err != nil {
+		return *new(PollOk), err
 	}
 
 	res := PollOk{
 		Msgs: msgs,
+		More: more,
+	}
+	return res, nil
+}
+
+// pollRecordsHandler polls as pollHandler, returning the messages and records as records.
+func (s *server) pollRecordsHandler(req PollRecords) (PollRecordsOk, error) {
+	read := func(l *ownedLog, from int, limit int) []Record {
+		return l.readRecords(from, limit, req.ReadCommitted)
+	}
+	remote := func(dest string, offsets map[string]int) (map[string][]Record, map[string]bool, error) {
+		forwarded := PollRecords{Offsets: offsets, ReadCommitted: req.ReadCommitted, PollLimits: req.PollLimits}
+		res, err := forward[PollRecords, PollRecordsOk](s.n, "poll_records", dest, forwarded)
+		return res.Records, res.More, err
+	}
+	records, more, err := pollKeys(s, req.Offsets, req.PollLimits, read, remote)
+	if err != nil {
+		return *new(PollRecordsOk), err
+	}
+
+	res := PollRecordsOk{
+		Records: records,
+		More:    more,
+	}
+	return res, nil
+}
+
+// readFunc reads a log from the offset, at most one more than the limit.
+type readFunc[T any] func(l *ownedLog, from int, limit int) []T
+
+// pollKeys reads the keys this node owns and forwards the others with remote, one request per owner, returning what
+// each key has within the limits and the keys with more left out.
+func pollKeys[T any](s *server, offsets map[string]int, limits PollLimits, read readFunc[T],
+	remote func(dest string, offsets map[string]int) (map[string][]T, map[string]bool, error),
+) (map[string][]T, map[string]bool, error) {
+	forwards := make(map[string]map[string]int)
+	local := make(map[string]int)
+	for key, offset := range offsets {
+		dest := keyToNodeID(messageLogKey(key), len(s.n.NodeIDs()))
+		if dest == s.n.ID() {
+			local[key] = offset
+			continue
+		}
+		if _, ok := forwards[dest]; !ok {
+			forwards[dest] = make(map[string]int)
+		}
+		forwards[dest][key] = offset
+	}
+
+	type reply struct {
+		items map[string][]T
+		more  map[string]bool
+	}
+	var mu sync.Mutex
+	var errs []error
+	replies := make([]reply, 0, len(forwards)+1)
+	var wg sync.WaitGroup
+	for dest, forwarded := range forwards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			items, more, err := remote(dest, forwarded)
+			mu.Lock()
+			defer mu.Unlock()
+			replies = append(replies, reply{items, more})
+			errs = append(errs, err)
+		}()
+	}
+	items, more, err := pollOwned(s, local, limits, read)
+	wg.Wait()
+	if err := errors.Join(append(errs, err)...); err != nil {
+		return nil, nil, err
+	}
+	replies = append(replies, reply{items, more})
+
+	// the forwarded replies are within the limits per owner, trimmed here to the limits of the request
+	items = make(map[string][]T)
+	more = make(map[string]bool)
+	budget := limits.Budget()
+	for _, reply := range replies {
+		for key, keyItems := range reply.items {
+			trimmed := false
+			items[key], trimmed = Take(budget, keyItems)
+			if trimmed || reply.more[key] {
+				more[key] = true
+			}
+		}
+	}
+	return items, more, nil
+}
+
+func pollOwned[T any](s *server, offsets map[string]int, limits PollLimits, read readFunc[T],
+) (map[string][]T, map[string]bool, error) {
+	items := make(map[string][]T)
+	more := make(map[string]bool)
+	budget := limits.Budget()
+	for key, offset := range offsets {
+		mu := s.mutex(messageLogKey(key))
+		mu.Lock()
+		l, err := s.ownedLog(key)
+		if err != nil {
+			mu.Unlock()
+			return nil, nil, err
+		}
+		from, err := retention.PollFrom(key, offset, l.start)
+		if err != nil {
+			mu.Unlock()
+			return nil, nil, err
+		}
+		keyItems := read(l, from, budget.Limit())
+		mu.Unlock()
+		keyItems, more[key] = Take(budget, keyItems)
+		if !more[key] {
+			delete(more, key)
+		}
+		items[key] = keyItems
+	}
+	return items, more, nil
+}
+
+// offsetForTimeHandler looks up the offsets of the keys this node owns in memory and forwards the others to their
+// owners.
+func (s *server) offsetForTimeHandler(req OffsetForTime) (OffsetForTimeOk, error) {
+	var mu sync.Mutex
+	offsets := make(map[string]int)
+	var errs []error
+	var wg sync.WaitGroup
+	for key, timestamp := range req.Timestamps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forwarded := OffsetForTime{Timestamps: map[string]int64{key: timestamp}}
+			res, err := toOwner(s, key, "offset_for_time", forwarded, s.offsetForTimeOwned)
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+			offsets[key] = res.Offsets[key]
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return *new(OffsetForTimeOk), err
+	}
+
+	res := OffsetForTimeOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+func (s *server) offsetForTimeOwned(req OffsetForTime) (OffsetForTimeOk, error) {
+	offsets := make(map[string]int)
+	for key, timestamp := range req.Timestamps {
+		mu := s.mutex(messageLogKey(key))
+		mu.Lock()
+		l, err := s.ownedLog(key)
+		if err != nil {
+			mu.Unlock()
+			return *new(OffsetForTimeOk), err
+		}
+		offsets[key] = l.offsetForTime(timestamp)
+		mu.Unlock()
+	}
+
+	res := OffsetForTimeOk{
+		Offsets: offsets,
 	}
 	return res, nil
 }
 
 func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error) {
+	if req.Member != "" { // fenced by the owner of the group
+		key := groupKey(req.Group)
+		dest := keyToNodeID(key, len(s.n.NodeIDs()))
+		if dest != s.n.ID() {
+			return forward[CommitOffsets, CommitOffsetsOk](s.n, "commit_offsets", dest, req)
+		}
+
+		mu := s.mutex(key)
+		mu.Lock()
+		defer mu.Unlock()
+		g, err := readGroup(s.kv, req.Group)
+		if err != nil {
+			return *new(CommitOffsetsOk), err
+		}
+		for key := range req.Offsets {
+			if !g.Owns(req.Member, req.Generation, key) {
+				return *new(CommitOffsetsOk), NotOwnerError(req.Group, req.Member, req.Generation, key)
+			}
+		}
+	}
+	if _, ok := s.registered.Load(req.Group); !ok {
+		if err := registerGroup(s.kv, req.Group); err != nil {
+			return *new(CommitOffsetsOk), err
+		}
+		s.registered.Store(req.Group, true)
+	}
+
 	for key, offset := range req.Offsets {
-		err := s.kv.Write(context.Background(), committedOffsetKey(key), offset)
+		err := s.kv.Write(context.Background(), committedOffsetKey(req.Group, key), offset)
 		if err != nil {
 			return *new(CommitOffsetsOk), err
 		}
@@ -117,7 +503,7 @@ func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error
 func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListCommittedOffsetsOk, error) {
 	offsets := make(map[string]int)
 	for _, key := range req.Keys {
-		committedOffset, err := utils.ReadOrElse(s.kv, committedOffsetKey(key), 0)
+		committedOffset, err := utils.ReadOrElse(s.kv, committedOffsetKey(req.Group, key), 0)
 		if err != nil {
 			return *new(ListCommittedOffsetsOk), err
 		}
@@ -129,3 +515,102 @@ func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListComm
 	}
 	return res, nil
 }
+
+func (s *server) joinGroupHandler(req JoinGroup) (JoinGroupOk, error) {
+	key := groupKey(req.Group)
+	dest := keyToNodeID(key, len(s.n.NodeIDs()))
+	if dest != s.n.ID() {
+		return forward[JoinGroup, JoinGroupOk](s.n, "join_group", dest, req)
+	}
+
+	mu := s.mutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+	g, err := updateGroup(s.kv, req.Group, func(g *ConsumerGroup) error {
+		g.Join(req.Member, req.Keys, time.Now())
+		return nil
+	})
+	if err != nil {
+		return *new(JoinGroupOk), err
+	}
+
+	res := JoinGroupOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) heartbeatHandler(req Heartbeat) (HeartbeatOk, error) {
+	key := groupKey(req.Group)
+	dest := keyToNodeID(key, len(s.n.NodeIDs()))
+	if dest != s.n.ID() {
+		return forward[Heartbeat, HeartbeatOk](s.n, "heartbeat", dest, req)
+	}
+
+	mu := s.mutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+	g, err := updateGroup(s.kv, req.Group, func(g *ConsumerGroup) error {
+		if !g.Heartbeat(req.Member, time.Now()) {
+			return UnknownMemberError(req.Group, req.Member)
+		}
+		return nil
+	})
+	if err != nil {
+		return *new(HeartbeatOk), err
+	}
+
+	res := HeartbeatOk{
+		Generation: g.Generation,
+		Keys:       g.Keys(req.Member),
+	}
+	return res, nil
+}
+
+func (s *server) leaveGroupHandler(req LeaveGroup) (LeaveGroupOk, error) {
+	key := groupKey(req.Group)
+	dest := keyToNodeID(key, len(s.n.NodeIDs()))
+	if dest != s.n.ID() {
+		return forward[LeaveGroup, LeaveGroupOk](s.n, "leave_group", dest, req)
+	}
+
+	mu := s.mutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := updateGroup(s.kv, req.Group, func(g *ConsumerGroup) error {
+		g.Leave(req.Member)
+		return nil
+	})
+	if err != nil {
+		return *new(LeaveGroupOk), err
+	}
+
+	res := LeaveGroupOk{}
+	return res, nil
+}
+
+// ownedLog returns the log of a key this node owns, loading it on first use, caller must hold the lock of the key.
+func (s *server) ownedLog(key string) (*ownedLog, error) {
+	if l, ok := s.logs.Get(key); ok {
+		return l, nil
+	}
+	l, err := loadLog(s.kv, key)
+	if err != nil {
+		return nil, err
+	}
+	s.logs.Set(key, l)
+	return l, nil
+}
+
+func (s *server) mutex(key string) *sync.Mutex {
+	s.mus.SetIfAbsent(key, new(sync.Mutex))
+	mu, _ := s.mus.Get(key)
+	return mu
+}
+
+func forward[Req any, Res any](n *maelstrom.Node, typ string, dest string, req Req) (Res, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+	return utils.SendWithContext[Req, Res](ctx, n, typ, dest, req)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// producerWindow is the number of sequence numbers remembered per producer and key, so a producer may retry any of its
+// last producerWindow sends, and have that many in flight arriving out of order.
+const producerWindow = 32
+
+// produced records the offset a sequence number of a producer was appended at.
+type produced struct {
+	Seq    int `json:"seq"`
+	Offset int `json:"offset"`
+}
+
+// ownedLog is the authoritative in-memory copy of a log kept by the owner of the key, written through to the KV before
+// being changed, so an append or a poll at the owner needs no KV reads. It holds the messages from the start of the
+// segment of the log start. All methods must be called holding the lock of the key.
+//
+// The last sequence numbers of each producer are written with the tail segment, so a retried send gets back its offset
+// also from a new owner, instead of being appended twice. So are the tags of the messages appended by transactions,
+// whose markers the owner keeps in memory, see txn.go.
+type ownedLog struct {
+	start      int
+	base       int // offset of msgs[0], a segment boundary
+	msgs       []message
+	timestamps []int64               // per message
+	records    map[int]record        // by offset
+	producers  map[string][]produced // the producerWindow highest sequence numbers appended per producer, increasing
+	txns       map[int]txnTag        // of the messages appended by transactions, by offset
+	markers    map[string]bool       // outcome of the transactions ended in the key, true if committed
+	open       map[string]*openTxn   // transactions with messages in memory not yet ended
+}
+
+// loadLog reads the log of the key from the KV, once per key as the owner is its single writer.
+func loadLog(kv utils.KV, key string) (*ownedLog, error) {
+	start, err := logStart(kv, key)
+	if err != nil {
+		return nil, err
+	}
+	l := &ownedLog{
+		start:     start,
+		base:      start / segmentSize * segmentSize,
+		records:   make(map[int]record),
+		producers: make(map[string][]produced),
+		txns:      make(map[int]txnTag),
+		markers:   make(map[string]bool),
+		open:      make(map[string]*openTxn),
+	}
+	for i := l.base / segmentSize; ; i++ {
+		seg, err := utils.ReadOrElse(kv, segmentKey(key, i), segment{})
+		if err != nil {
+			return nil, err
+		}
+		l.msgs = append(l.msgs, seg.Msgs...)
+		l.timestamps = append(l.timestamps, seg.timestamps()...)
+		maps.Copy(l.records, seg.Records)
+		if seg.Producers != nil {
+			l.producers = seg.Producers
+		}
+		maps.Copy(l.txns, seg.Txns)
+		if !seg.full() {
+			break
+		}
+	}
+	for _, tag := range l.txns {
+		if _, ok := l.open[tag.Txn]; ok {
+			continue
+		}
+		if _, ended, err := l.marker(kv, key, tag.Txn); err != nil {
+			return nil, err
+		} else if !ended {
+			l.open[tag.Txn] = &openTxn{primary: tag.Primary, since: time.Now()}
+		}
+	}
+	return l, nil
+}
+
+func (l *ownedLog) end() int {
+	return l.base + len(l.msgs)
+}
+
+func (l *ownedLog) append(kv utils.KV, key string, msg KeyedMsg) (int, error) {
+	offsets, err := l.appendBatch(kv, key, []KeyedMsg{msg}, txnTag{})
+	if err != nil {
+		return *new(int), err
+	}
+	return offsets[0], nil
+}
+
+// appendRecord appends the record, returning its offset and timestamp.
+func (l *ownedLog) appendRecord(kv utils.KV, key string, r record) (int, int64, error) {
+	offset := l.end()
+	l.records[offset] = r // written with the segment of its placeholder
+	if _, err := l.append(kv, key, KeyedMsg{Key: key}); err != nil {
+		delete(l.records, offset)
+		return *new(int), *new(int64), err
+	}
+	return offset, l.timestamps[offset-l.base], nil
+}
+
+// appendBatch appends the messages with one write per segment they fill, returning their offsets. A message with a
+// sequence number its producer already sent is not appended again but gets the offset of the first, and a batch with
+// a sequence number too old to tell, below those remembered, is rejected as a whole. The messages are tagged with the transaction, if any.
+func (l *ownedLog) appendBatch(kv utils.KV, key string, msgs []KeyedMsg, txn txnTag) ([]int, error) {
+	offsets := make([]int, len(msgs))
+	var fresh []message
+	producers := cloneProducers(l.producers)
+	var accepted []map[string][]produced // the producers as of each fresh message, if any sent by a producer
+	for i, msg := range msgs {
+		window := producers[msg.Producer]
+		j, sent := slices.BinarySearchFunc(window, msg.Seq, func(p produced, seq int) int { return p.Seq - seq })
+		if msg.Producer != "" && sent {
+			offsets[i] = window[j].Offset
+			continue
+		}
+		if msg.Producer != "" && len(window) >= producerWindow && j == 0 {
+			return nil, maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+				fmt.Sprintf("sequence %d of producer %s is older than its last %d", msg.Seq, msg.Producer, len(window)))
+		}
+		offsets[i] = l.end() + len(fresh)
+		fresh = append(fresh, msg.Msg)
+		if msg.Producer != "" {
+			window = slices.Insert(window, j, produced{Seq: msg.Seq, Offset: offsets[i]})
+			producers[msg.Producer] = window[max(len(window)-producerWindow, 0):]
+			accepted = append(accepted, cloneProducers(producers))
+		} else {
+			accepted = append(accepted, nil)
+		}
+	}
+
+	written := 0
+	for written < len(fresh) {
+		offset := l.end()
+		tail := offset / segmentSize
+		if offset%segmentSize == 0 && offset > 0 {
+			if err := kv.Write(context.Background(), messageLogKey(key), tail); err != nil {
+				return nil, err
+			}
+		}
+		n := min(len(fresh)-written, (tail+1)*segmentSize-offset)
+		chunk := fresh[written : written+n]
+		producers := l.producers
+		for _, p := range accepted[written : written+n] {
+			if p != nil {
+				producers = p
+			}
+		}
+		tailMsgs := l.msgs[tail*segmentSize-l.base:]
+		now := time.Now().UnixMilli()
+		if len(l.timestamps) > 0 {
+			now = max(now, l.timestamps[len(l.timestamps)-1])
+		}
+		timestamps := slices.Clone(l.timestamps[tail*segmentSize-l.base:])
+		for range chunk {
+			timestamps = append(timestamps, now)
+		}
+		records := make(map[int]record)
+		for o, r := range l.records {
+			if o >= tail*segmentSize {
+				records[o] = r
+			}
+		}
+		txns := make(map[int]txnTag)
+		for o, tag := range l.txns {
+			if o >= tail*segmentSize {
+				txns[o] = tag
+			}
+		}
+		if txn.Txn != "" {
+			for i := range chunk {
+				txns[offset+i] = txn
+			}
+		}
+		appended := segment{
+			Msgs:       append(tailMsgs[:len(tailMsgs):len(tailMsgs)], chunk...),
+			Timestamps: timestamps,
+			Records:    records,
+			Last:       now,
+			Producers:  producers,
+			Txns:       txns,
+		}
+		if err := kv.Write(context.Background(), segmentKey(key, tail), appended); err != nil {
+			return nil, err
+		}
+		l.msgs = append(l.msgs, chunk...)
+		l.timestamps = append(l.timestamps, timestamps[len(tailMsgs):]...)
+		l.producers = producers
+		maps.Copy(l.txns, txns)
+		if _, ok := l.open[txn.Txn]; txn.Txn != "" && !ok {
+			l.open[txn.Txn] = &openTxn{primary: txn.Primary, since: time.Now()}
+		}
+		written += n
+	}
+	return offsets, nil
+}
+
+func cloneProducers(producers map[string][]produced) map[string][]produced {
+	cloned := make(map[string][]produced, len(producers))
+	for producer, window := range producers {
+		cloned[producer] = slices.Clone(window)
+	}
+	return cloned
+}
+
+// read returns the messages from the offset, leaving out the records, at most one more than the limit.
+func (l *ownedLog) read(offset int, limit int, readCommitted bool) [][2]int {
+	var pairs [][2]int
+	l.scan(offset, readCommitted, func(i int) bool {
+		if _, ok := l.records[l.base+i]; !ok {
+			pairs = append(pairs, [2]int{l.base + i, l.msgs[i]})
+		}
+		return len(pairs) <= limit
+	})
+	return pairs
+}
+
+// readRecords returns the messages and records from the offset as records, a message having its int as value, at most
+// one more than the limit.
+func (l *ownedLog) readRecords(offset int, limit int, readCommitted bool) []Record {
+	records := []Record{}
+	l.scan(offset, readCommitted, func(i int) bool {
+		r, ok := l.records[l.base+i]
+		if !ok {
+			r.Value = json.RawMessage(strconv.Itoa(l.msgs[i]))
+		}
+		records = append(records, Record{Offset: l.base + i, Value: r.Value, Headers: r.Headers, Timestamp: l.timestamps[i]})
+		return len(records) <= limit
+	})
+	return records
+}
+
+// scan calls f with the index in msgs of each message from the offset until it returns false. If readCommitted, those
+// of aborted transactions are skipped and it stops at the first of an open transaction, as later messages may be
+// followed by its commit.
+func (l *ownedLog) scan(offset int, readCommitted bool, f func(i int) bool) {
+	for i := max(offset, l.base) - l.base; i < len(l.msgs); i++ {
+		if tag, ok := l.txns[l.base+i]; readCommitted && ok {
+			committed, ended := l.markers[tag.Txn]
+			if !ended {
+				return
+			}
+			if !committed {
+				continue
+			}
+		}
+		if !f(i) {
+			return
+		}
+	}
+}
+
+// offsetForTime returns the offset of the first message or record with a timestamp at or after the given one, else the
+// end of the log.
+func (l *ownedLog) offsetForTime(timestamp int64) int {
+	i := sort.Search(len(l.timestamps), func(i int) bool { return l.timestamps[i] >= timestamp })
+	return max(l.base+i, l.start)
+}
+
+// truncate applies the retention to the KV and drops the segments before the new log start from memory.
+func (l *ownedLog) truncate(kv utils.KV, key string, r Retention) error {
+	if err := truncateLog(kv, key, r); err != nil {
+		return err
+	}
+	start, err := logStart(kv, key)
+	if err != nil {
+		return err
+	}
+	base := start / segmentSize * segmentSize
+	l.msgs = append([]message(nil), l.msgs[base-l.base:]...) // copies to release the dropped prefix
+	l.timestamps = append([]int64(nil), l.timestamps[base-l.base:]...)
+	for o := range l.records {
+		if o < base {
+			delete(l.records, o)
+		}
+	}
+	l.start, l.base = start, base
+
+	// the markers are kept in the KV, read again if asked for a dropped transaction
+	kept := make(map[string]bool)
+	for o, tag := range l.txns {
+		if o < base {
+			delete(l.txns, o)
+		} else {
+			kept[tag.Txn] = true
+		}
+	}
+	for txn := range l.markers {
+		if !kept[txn] {
+			delete(l.markers, txn)
+		}
+	}
+	for txn := range l.open {
+		if !kept[txn] {
+			delete(l.open, txn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+func TestOwnedLog_Records(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.append(kv, "k", KeyedMsg{Msg: 7}); err != nil {
+		t.Fatal(err)
+	}
+	offset, timestamp, err := l.appendRecord(kv, "k", record{Value: json.RawMessage(`{"a":1}`), Headers: map[string]string{"h": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 1 || timestamp < l.timestamps[0] {
+		t.Errorf("appendRecord = %d at %d, want offset 1 no earlier than %d", offset, timestamp, l.timestamps[0])
+	}
+	if _, err := l.append(kv, "k", KeyedMsg{Msg: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadLog(kv, "k") // as written through
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, l := range map[string]*ownedLog{"in memory": l, "reloaded": reloaded} {
+		t.Run(name, func(t *testing.T) {
+			if got, want := l.read(0, math.MaxInt, false), [][2]int{{0, 7}, {2, 8}}; !slices.Equal(got, want) {
+				t.Errorf("read = %v, want %v leaving out the record", got, want)
+			}
+			records := l.readRecords(0, math.MaxInt, false)
+			if len(records) != 3 || string(records[0].Value) != "7" || string(records[1].Value) != `{"a":1}` ||
+				records[1].Headers["h"] != "v" || records[1].Timestamp != timestamp || string(records[2].Value) != "8" {
+				t.Errorf("readRecords = %+v, want the messages and the record", records)
+			}
+			if got := l.offsetForTime(timestamp + 1_000_000); got != 3 {
+				t.Errorf("offsetForTime after the last = %d, want the end 3", got)
+			}
+			if got := l.offsetForTime(0); got != 0 {
+				t.Errorf("offsetForTime(0) = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -1,32 +1,141 @@
 package main
 
+import (
+	"encoding/json"
+
+	. "github.com/tobiajo/gossip-gloomers/common"
+)
+
 type Send struct {
-	Key string `json:"key"`
-	Msg int    `json:"msg"`
+	Key      string `json:"key"`
+	Msg      int    `json:"msg"`
+	Producer string `json:"producer,omitempty"` // makes the send idempotent, with the sequence number
+	Seq      int    `json:"seq,omitempty"`      // unique per producer and key, may arrive out of order
 }
 
 type SendOk struct {
 	Offset int `json:"offset"`
 }
 
+type SendBatch struct {
+	Msgs []KeyedMsg `json:"msgs"`
+}
+
+type KeyedMsg struct {
+	Key      string `json:"key"`
+	Msg      int    `json:"msg"`
+	Producer string `json:"producer,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
+}
+
+type SendBatchOk struct {
+	Offsets []*int            `json:"offsets"`          // per message, null if its key failed
+	Errors  map[string]string `json:"errors,omitempty"` // per failed key
+}
+
+// SendTxn appends the messages to their keys atomically, all committed or all aborted.
+type SendTxn struct {
+	Msgs []KeyedMsg `json:"msgs"`
+}
+
+type SendTxnOk struct {
+	Offsets []int `json:"offsets"`
+}
+
+// SendRecord sends a record with an arbitrary JSON value, timestamped by the broker.
+type SendRecord struct {
+	Key     string            `json:"key"`
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type SendRecordOk struct {
+	Offset    int   `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+}
+
 type Poll struct {
-	Offsets map[string]int `json:"offsets"`
+	Offsets       map[string]int `json:"offsets"`
+	ReadCommitted bool           `json:"read_committed,omitempty"` // hides the messages of aborted and open transactions
+	PollLimits
 }
 
 type PollOk struct {
 	Msgs map[string][][2]int `json:"msgs"`
+	More map[string]bool     `json:"more,omitempty"` // keys with messages left out by the limits
+}
+
+// PollRecords polls the messages and records of the keys as records, a message having its int as value.
+type PollRecords struct {
+	Offsets       map[string]int `json:"offsets"`
+	ReadCommitted bool           `json:"read_committed,omitempty"`
+	PollLimits
+}
+
+type PollRecordsOk struct {
+	Records map[string][]Record `json:"records"`
+	More    map[string]bool     `json:"more,omitempty"`
+}
+
+type Record struct {
+	Offset    int               `json:"offset"`
+	Value     json.RawMessage   `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp int64             `json:"timestamp"` // unix millis
+}
+
+// OffsetForTime looks up the first offset per key with a timestamp at or after the given one, to poll from.
+type OffsetForTime struct {
+	Timestamps map[string]int64 `json:"timestamps"` // unix millis
+}
+
+type OffsetForTimeOk struct {
+	Offsets map[string]int `json:"offsets"` // the end of the log if no later message
 }
 
 type CommitOffsets struct {
-	Offsets map[string]int `json:"offsets"`
+	Group      string         `json:"group,omitempty"`  // the default group if empty
+	Member     string         `json:"member,omitempty"` // if set, fenced unless owning the keys in the generation
+	Generation int            `json:"generation,omitempty"`
+	Offsets    map[string]int `json:"offsets"`
 }
 
 type CommitOffsetsOk struct{}
 
 type ListCommittedOffsets struct {
-	Keys []string `json:"keys"`
+	Group string   `json:"group,omitempty"`
+	Keys  []string `json:"keys"`
 }
 
 type ListCommittedOffsetsOk struct {
 	Offsets map[string]int `json:"offsets"`
 }
+
+type JoinGroup struct {
+	Group  string   `json:"group"`
+	Member string   `json:"member"`
+	Keys   []string `json:"keys"` // subscribed
+}
+
+type JoinGroupOk struct {
+	Generation int      `json:"generation"`
+	Keys       []string `json:"keys"` // assigned
+}
+
+type Heartbeat struct {
+	Group  string `json:"group"`
+	Member string `json:"member"`
+}
+
+// HeartbeatOk carries the current assignment, a new generation tells the member that the keys were rebalanced.
+type HeartbeatOk struct {
+	Generation int      `json:"generation"`
+	Keys       []string `json:"keys"`
+}
+
+type LeaveGroup struct {
+	Group  string `json:"group"`
+	Member string `json:"member"`
+}
+
+type LeaveGroupOk struct{}
@@ -0,0 +1,24 @@
+package main
+
+// TxnAppend appends the messages of the transaction to the key at its owner, as not yet committed.
+type TxnAppend struct {
+	Key     string    `json:"key"`
+	Txn     string    `json:"txn"`
+	Primary string    `json:"primary"` // the key whose owner decides the outcome of the transaction
+	Msgs    []message `json:"msgs"`
+}
+
+type TxnAppendOk struct {
+	Offsets []int `json:"offsets"`
+}
+
+// TxnEnd sets the marker ending the transaction in the key at its owner, unless it already ended.
+type TxnEnd struct {
+	Key    string `json:"key"`
+	Txn    string `json:"txn"`
+	Commit bool   `json:"commit"`
+}
+
+type TxnEndOk struct {
+	Committed bool `json:"committed"` // the outcome, which may differ from the request if ended before
+}
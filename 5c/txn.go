@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	markerCommit = "commit"
+	markerAbort  = "abort"
+	txnTimeout   = 5 * time.Second // before the owner of a key aborts a transaction left open in it
+)
+
+// A transaction appends its messages to each of its keys at their owners, tagged with its id, then ends with a marker
+// in each key, as Kafka's transaction markers. The first key of the transaction is its primary, as in Percolator, the
+// marker set first at the owner of the primary decides the outcome, so the coordinator and the owners resolving a
+// transaction of a failed coordinator agree on it. A marker is only set in the other keys after the primary has one,
+// and no message of a transaction is appended to a key after its marker.
+//
+// A marker is written to the KV under messageLog:<key>:txn:<txn> by the owner of the key, as its single writer, and the
+// tags of the messages with the segment holding them, so a new owner knows the transactions left open.
+// https://www.confluent.io/blog/transactions-apache-kafka/
+// https://research.google/pubs/large-scale-incremental-processing-using-distributed-transactions-and-notifications/
+
+// txnTag tags a message appended by a transaction.
+type txnTag struct {
+	Txn     string `json:"txn"`
+	Primary string `json:"primary"`
+}
+
+type openTxn struct {
+	primary   string
+	since     time.Time // first seen by the owner
+	resolving bool
+}
+
+func txnMarkerKey(key string, txn string) string {
+	return messageLogKey(key) + ":txn:" + txn
+}
+
+// sendTxnHandler coordinates a transaction appending the messages to their keys, all committed or all aborted.
+func (s *server) sendTxnHandler(req SendTxn) (SendTxnOk, error) {
+	if len(req.Msgs) == 0 {
+		return SendTxnOk{Offsets: []int{}}, nil
+	}
+	txn := s.n.ID() + "-" + s.run + "-" + strconv.FormatInt(s.txns.Add(1), 10)
+	primary := req.Msgs[0].Key
+	byKey := batchByKey(req.Msgs)
+
+	var mu sync.Mutex
+	offsets := make([]int, len(req.Msgs))
+	var appendErr error
+	var wg sync.WaitGroup
+	for key, indices := range byKey {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appended := TxnAppend{Key: key, Txn: txn, Primary: primary}
+			for _, j := range indices {
+				appended.Msgs = append(appended.Msgs, req.Msgs[j].Msg)
+			}
+			res, err := toOwner(s, key, "txn_append", appended, s.txnAppendHandler)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if appendErr == nil {
+					appendErr = err
+				}
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = res.Offsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	res, err := s.endTxn(primary, txn, appendErr == nil)
+	if err != nil {
+		return *new(SendTxnOk), err // the open transaction is resolved by the owners of its keys
+	}
+	for key := range byKey {
+		if key == primary {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.endTxn(key, txn, res.Committed); err != nil {
+				log.Printf("error end %s in %s: %v", txn, key, err) // resolved by the owner of the key
+			}
+		}()
+	}
+	wg.Wait()
+	if !res.Committed {
+		if appendErr == nil {
+			appendErr = errors.New("aborted")
+		}
+		return *new(SendTxnOk), maelstrom.NewRPCError(maelstrom.TxnConflict, fmt.Sprintf("%s aborted: %v", txn, appendErr))
+	}
+
+	return SendTxnOk{Offsets: offsets}, nil
+}
+
+func (s *server) txnAppendHandler(req TxnAppend) (TxnAppendOk, error) {
+	msgs := make([]KeyedMsg, len(req.Msgs))
+	for i, msg := range req.Msgs {
+		msgs[i] = KeyedMsg{Key: req.Key, Msg: msg}
+	}
+	offsets, err := s.appendOwned(req.Key, msgs, txnTag{Txn: req.Txn, Primary: req.Primary})
+	if err != nil {
+		return *new(TxnAppendOk), err
+	}
+
+	res := TxnAppendOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+func (s *server) txnEndHandler(req TxnEnd) (TxnEndOk, error) {
+	mu := s.mutex(messageLogKey(req.Key))
+	mu.Lock()
+	defer mu.Unlock()
+	l, err := s.ownedLog(req.Key)
+	if err != nil {
+		return *new(TxnEndOk), err
+	}
+	committed, err := l.endTxn(s.kv, req.Key, req.Txn, req.Commit)
+	if err != nil {
+		return *new(TxnEndOk), err
+	}
+
+	res := TxnEndOk{
+		Committed: committed,
+	}
+	return res, nil
+}
+
+// endTxn ends the transaction in the key, at its owner, returning the outcome.
+func (s *server) endTxn(key string, txn string, commit bool) (TxnEndOk, error) {
+	return toOwner(s, key, "txn_end", TxnEnd{Key: key, Txn: txn, Commit: commit}, s.txnEndHandler)
+}
+
+// resolveExpired resolves the transactions left open for longer than txnTimeout in the keys this node owns.
+func (s *server) resolveExpired() {
+	for key, l := range s.logs.Items() {
+		mu := s.mutex(messageLogKey(key))
+		mu.Lock()
+		expired := l.expiredTxns()
+		mu.Unlock()
+		for txn, primary := range expired {
+			go s.resolve(key, l, txn, primary)
+		}
+	}
+}
+
+// resolve ends a transaction left open in the key by its coordinator with the outcome of its primary, aborting it there
+// if not yet decided.
+func (s *server) resolve(key string, l *ownedLog, txn string, primary string) {
+	res, err := s.endTxn(primary, txn, false)
+	if err == nil {
+		_, err = s.endTxn(key, txn, res.Committed)
+	}
+	if err != nil {
+		log.Printf("error resolve %s in %s: %v", txn, key, err)
+		mu := s.mutex(messageLogKey(key))
+		mu.Lock()
+		if o, ok := l.open[txn]; ok {
+			o.resolving = false // retried on a later tick
+		}
+		mu.Unlock()
+	}
+}
+
+// toOwner handles the request at the owner of the key, forwarding it unless this node.
+func toOwner[Req any, Res any](s *server, key string, typ string, req Req, handle func(Req) (Res, error)) (Res, error) {
+	dest := keyToNodeID(messageLogKey(key), len(s.n.NodeIDs()))
+	if dest == s.n.ID() {
+		return handle(req)
+	}
+	return forward[Req, Res](s.n, typ, dest, req)
+}
+
+// marker returns the outcome of the transaction in the key, if ended, reading it from the KV unless known.
+func (l *ownedLog) marker(kv utils.KV, key string, txn string) (bool, bool, error) {
+	if committed, ok := l.markers[txn]; ok {
+		return committed, true, nil
+	}
+	marker, err := utils.ReadOrElse(kv, txnMarkerKey(key, txn), "")
+	if err != nil || marker == "" {
+		return false, false, err
+	}
+	l.markers[txn] = marker == markerCommit
+	return marker == markerCommit, true, nil
+}
+
+// endTxn sets the marker of the outcome, unless the transaction already ended in the key, and returns the outcome.
+func (l *ownedLog) endTxn(kv utils.KV, key string, txn string, commit bool) (bool, error) {
+	committed, ended, err := l.marker(kv, key, txn)
+	if err != nil || ended {
+		return committed, err
+	}
+	marker := markerAbort
+	if commit {
+		marker = markerCommit
+	}
+	if err := kv.Write(context.Background(), txnMarkerKey(key, txn), marker); err != nil {
+		return false, err
+	}
+	l.markers[txn] = commit
+	delete(l.open, txn)
+	return commit, nil
+}
+
+// expiredTxns returns the transactions open for longer than txnTimeout, not already being resolved, with their
+// primaries.
+func (l *ownedLog) expiredTxns() map[string]string {
+	expired := make(map[string]string)
+	for txn, o := range l.open {
+		if !o.resolving && time.Since(o.since) > txnTimeout {
+			o.resolving = true
+			expired[txn] = o.primary
+		}
+	}
+	return expired
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+func TestOwnedLog_ReadCommitted(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appends := []struct {
+		msg int
+		txn txnTag
+	}{
+		{0, txnTag{}},
+		{1, txnTag{Txn: "t1", Primary: "k"}},
+		{2, txnTag{Txn: "t2", Primary: "k"}},
+		{3, txnTag{Txn: "t3", Primary: "other"}},
+		{4, txnTag{}},
+	}
+	for _, a := range appends {
+		if _, err := l.appendBatch(kv, "k", []KeyedMsg{{Msg: a.msg}}, a.txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for txn, commit := range map[string]bool{"t1": true, "t2": false} {
+		if _, err := l.endTxn(kv, "k", txn, commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if committed, err := l.endTxn(kv, "k", "t2", true); err != nil || committed {
+		t.Errorf("end of an aborted transaction = %v, %v, want aborted", committed, err)
+	}
+
+	reloaded, err := loadLog(kv, "k") // as written through
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, l := range map[string]*ownedLog{"in memory": l, "reloaded": reloaded} {
+		t.Run(name, func(t *testing.T) {
+			want := [][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}
+			if got := l.read(0, math.MaxInt, false); !slices.Equal(got, want) {
+				t.Errorf("read uncommitted = %v, want %v", got, want)
+			}
+			want = [][2]int{{0, 0}, {1, 1}} // t2 aborted, stopped at the open t3
+			if got := l.read(0, math.MaxInt, true); !slices.Equal(got, want) {
+				t.Errorf("read committed = %v, want %v", got, want)
+			}
+			if _, ok := l.open["t3"]; !ok || len(l.open) != 1 {
+				t.Errorf("open = %v, want t3", l.open)
+			}
+		})
+	}
+
+	if _, err := reloaded.endTxn(kv, "k", "t3", true); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]int{{0, 0}, {1, 1}, {3, 3}, {4, 4}}
+	if got := reloaded.read(0, math.MaxInt, true); !slices.Equal(got, want) {
+		t.Errorf("read committed after t3 = %v, want %v", got, want)
+	}
+}
+
+func TestOwnedLog_ExpiredTxns(t *testing.T) {
+	kv := utils.NewMemKV()
+	l, err := loadLog(kv, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.appendBatch(kv, "k", []KeyedMsg{{Msg: 1}, {Msg: 2}}, txnTag{Txn: "t1", Primary: "p"}); err != nil {
+		t.Fatal(err)
+	}
+	if expired := l.expiredTxns(); len(expired) != 0 {
+		t.Errorf("expired = %v, want none yet", expired)
+	}
+
+	l.open["t1"].since = time.Now().Add(-2 * txnTimeout)
+	if expired := l.expiredTxns(); len(expired) != 1 || expired["t1"] != "p" {
+		t.Errorf("expired = %v, want t1 of primary p", expired)
+	}
+	if expired := l.expiredTxns(); len(expired) != 0 {
+		t.Errorf("expired = %v, want none while resolving", expired)
+	}
+	if _, err := l.endTxn(kv, "k", "t1", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.open) != 0 {
+		t.Errorf("open = %v, want none after the marker", l.open)
+	}
+}
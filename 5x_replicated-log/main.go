@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+type server struct {
+	n          *maelstrom.Node
+	partitions cmap.ConcurrentMap[string, *partition] // of the keys this node replicates
+	hintsMu    sync.Mutex
+	hints      map[string]Leader // last known leaders, for routing
+	txns       atomic.Int64      // transactions coordinated by this node
+}
+
+type nodeID = string
+type message = int
+
+// Kafka-style log replicated by the nodes themselves, without Maelstrom's KV services. Each key is replicated on the
+// replicationFactor nodes following it on the ring, one of them leading, see partition. Requests are routed to the
+// leader of the key, which acknowledges a send once a quorum of the replicas has it and polls read committed messages.
+func main() {
+	n := maelstrom.NewNode()
+	s := server{
+		n:          n,
+		partitions: cmap.New[*partition](),
+		hints:      make(map[string]Leader),
+	}
+
+	go func() {
+		for range time.Tick(heartbeatInterval) {
+			s.tick()
+		}
+	}()
+
+	// external
+	utils.RegisterHandler(n, "send", s.sendHandler)
+	utils.RegisterHandler(n, "send_batch", s.sendBatchHandler)
+	utils.RegisterHandler(n, "send_txn", s.sendTxnHandler)
+	utils.RegisterHandler(n, "send_record", s.sendRecordHandler)
+	utils.RegisterHandler(n, "poll", s.pollHandler)
+	utils.RegisterHandler(n, "poll_records", s.pollRecordsHandler)
+	utils.RegisterHandler(n, "offset_for_time", s.offsetForTimeHandler)
+	utils.RegisterHandler(n, "commit_offsets", s.commitOffsetsHandler)
+	utils.RegisterHandler(n, "list_committed_offsets", s.listCommittedOffsetsHandler)
+
+	// internal
+	utils.RegisterHandler(n, "replicate", s.replicateHandler)
+	utils.RegisterHandler(n, "request_vote", s.requestVoteHandler)
+	utils.RegisterAsyncHandler(n, "leader", s.leaderHandler)
+	utils.RegisterHandler(n, "txn_append", s.txnAppendHandler)
+	utils.RegisterHandler(n, "txn_end", s.txnEndHandler)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *server) sendHandler(req Send) (SendOk, error) {
+	fwd := req
+	fwd.Forwarded = true
+	return route(s, req.Key, "send", req.Forwarded, fwd, func(p *partition) (SendOk, error) {
+		res, err := s.appendBatch(p, []KeyedMsg{{Key: req.Key, Msg: req.Msg}})
+		if err != nil {
+			return *new(SendOk), err
+		}
+		return SendOk{Offset: *res.Offsets[0]}, nil
+	})
+}
+
+func (s *server) sendBatchHandler(req SendBatch) (SendBatchOk, error) {
+	byKey := make(map[string][]int)
+	for i, msg := range req.Msgs {
+		byKey[msg.Key] = append(byKey[msg.Key], i)
+	}
+
+	var mu sync.Mutex
+	offsets := make([]*int, len(req.Msgs))
+	errs := make(map[string]string)
+	var wg sync.WaitGroup
+	for key, indices := range byKey {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fwd := SendBatch{Forwarded: true}
+			for _, j := range indices {
+				fwd.Msgs = append(fwd.Msgs, req.Msgs[j])
+			}
+			res, err := route(s, key, "send_batch", req.Forwarded, fwd, func(p *partition) (SendBatchOk, error) {
+				return s.appendBatch(p, fwd.Msgs)
+			})
+			if err == nil && res.Errors[key] != "" {
+				err = errors.New(res.Errors[key])
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err.Error()
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = res.Offsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := SendBatchOk{
+		Offsets: offsets,
+		Errors:  errs,
+	}
+	return res, nil
+}
+
+// appendBatch appends the messages of the key led by this node and replicates them to a quorum.
+func (s *server) appendBatch(p *partition, msgs []KeyedMsg) (SendBatchOk, error) {
+	entries := make([]entry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = entry{Msg: &msg.Msg}
+	}
+	first, err := s.appendEntries(p, entries, nil)
+	if err != nil {
+		return *new(SendBatchOk), err
+	}
+	res := SendBatchOk{
+		Offsets: make([]*int, len(msgs)),
+	}
+	for i := range msgs {
+		offset := first + i
+		res.Offsets[i] = &offset
+	}
+	return res, nil
+}
+
+// appendEntries appends the entries in the epoch of the leader, if valid under the lock, and replicates them to a
+// quorum, returning the offset of the first.
+func (s *server) appendEntries(p *partition, entries []entry, valid func() error) (int, error) {
+	p.mu.Lock()
+	if p.leader != s.n.ID() {
+		p.mu.Unlock()
+		return *new(int), notLeaderError(p.key)
+	}
+	if valid != nil {
+		if err := valid(); err != nil {
+			p.mu.Unlock()
+			return *new(int), err
+		}
+	}
+	first, epoch, now := len(p.log), p.epoch, p.now()
+	for _, e := range entries {
+		e.Epoch, e.Timestamp = epoch, now
+		p.append(e)
+	}
+	p.mu.Unlock()
+
+	s.replicateAll(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last := first + len(entries) - 1
+	if p.commit <= last || p.log[last].Epoch != epoch {
+		return *new(int), maelstrom.NewRPCError(maelstrom.Timeout, "not replicated to a quorum")
+	}
+	return first, nil
+}
+
+func (s *server) pollHandler(req Poll) (PollOk, error) {
+	var mu sync.Mutex
+	msgs := make(map[string][][2]int)
+	err := forEachKey(req.Offsets, func(key string, offset int) error {
+		fwd := Poll{Offsets: map[string]int{key: offset}, ReadCommitted: req.ReadCommitted, Forwarded: true}
+		res, err := route(s, key, "poll", req.Forwarded, fwd, func(p *partition) (PollOk, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return PollOk{Msgs: map[string][][2]int{key: p.read(offset, req.ReadCommitted)}}, nil
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		msgs[key] = res.Msgs[key]
+		return nil
+	})
+	if err != nil {
+		return *new(PollOk), err
+	}
+
+	res := PollOk{
+		Msgs: msgs,
+	}
+	return res, nil
+}
+
+func (s *server) sendRecordHandler(req SendRecord) (SendRecordOk, error) {
+	if req.Value == nil {
+		return *new(SendRecordOk), maelstrom.NewRPCError(maelstrom.MalformedRequest, "record without value")
+	}
+	fwd := req
+	fwd.Forwarded = true
+	return route(s, req.Key, "send_record", req.Forwarded, fwd, func(p *partition) (SendRecordOk, error) {
+		offset, err := s.appendEntries(p, []entry{{Value: req.Value, Headers: req.Headers}}, nil)
+		if err != nil {
+			return *new(SendRecordOk), err
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return SendRecordOk{Offset: offset, Timestamp: p.log[offset].Timestamp}, nil
+	})
+}
+
+func (s *server) pollRecordsHandler(req PollRecords) (PollRecordsOk, error) {
+	var mu sync.Mutex
+	records := make(map[string][]Record)
+	err := forEachKey(req.Offsets, func(key string, offset int) error {
+		fwd := PollRecords{Offsets: map[string]int{key: offset}, ReadCommitted: req.ReadCommitted, Forwarded: true}
+		res, err := route(s, key, "poll_records", req.Forwarded, fwd, func(p *partition) (PollRecordsOk, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return PollRecordsOk{Records: map[string][]Record{key: p.records(offset, req.ReadCommitted)}}, nil
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		records[key] = res.Records[key]
+		return nil
+	})
+	if err != nil {
+		return *new(PollRecordsOk), err
+	}
+
+	res := PollRecordsOk{
+		Records: records,
+	}
+	return res, nil
+}
+
+func (s *server) offsetForTimeHandler(req OffsetForTime) (OffsetForTimeOk, error) {
+	var mu sync.Mutex
+	offsets := make(map[string]int)
+	err := forEachKey(req.Timestamps, func(key string, timestamp int64) error {
+		fwd := OffsetForTime{Timestamps: map[string]int64{key: timestamp}, Forwarded: true}
+		res, err := route(s, key, "offset_for_time", req.Forwarded, fwd, func(p *partition) (OffsetForTimeOk, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return OffsetForTimeOk{Offsets: map[string]int{key: p.offsetForTime(timestamp)}}, nil
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		offsets[key] = res.Offsets[key]
+		return nil
+	})
+	if err != nil {
+		return *new(OffsetForTimeOk), err
+	}
+
+	res := OffsetForTimeOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+func (s *server) commitOffsetsHandler(req CommitOffsets) (CommitOffsetsOk, error) {
+	err := forEachKey(req.Offsets, func(key string, offset int) error {
+		fwd := CommitOffsets{Offsets: map[string]int{key: offset}, Forwarded: true}
+		_, err := route(s, key, "commit_offsets", req.Forwarded, fwd, func(p *partition) (CommitOffsetsOk, error) {
+			p.mu.Lock()
+			p.consumerOffset = offset
+			p.mu.Unlock()
+			if s.replicateAll(p) < p.quorum() {
+				return *new(CommitOffsetsOk), maelstrom.NewRPCError(maelstrom.Timeout, "not replicated to a quorum")
+			}
+			return CommitOffsetsOk{}, nil
+		})
+		return err
+	})
+	if err != nil {
+		return *new(CommitOffsetsOk), err
+	}
+
+	res := CommitOffsetsOk{}
+	return res, nil
+}
+
+func (s *server) listCommittedOffsetsHandler(req ListCommittedOffsets) (ListCommittedOffsetsOk, error) {
+	keys := make(map[string]int)
+	for _, key := range req.Keys {
+		keys[key] = 0
+	}
+	var mu sync.Mutex
+	offsets := make(map[string]int)
+	err := forEachKey(keys, func(key string, _ int) error {
+		fwd := ListCommittedOffsets{Keys: []string{key}, Forwarded: true}
+		res, err := route(s, key, "list_committed_offsets", req.Forwarded, fwd, func(p *partition) (ListCommittedOffsetsOk, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return ListCommittedOffsetsOk{Offsets: map[string]int{key: p.consumerOffset}}, nil
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		offsets[key] = res.Offsets[key]
+		return nil
+	})
+	if err != nil {
+		return *new(ListCommittedOffsetsOk), err
+	}
+
+	res := ListCommittedOffsetsOk{
+		Offsets: offsets,
+	}
+	return res, nil
+}
+
+func (s *server) leaderHandler(req Leader) error {
+	s.hintsMu.Lock()
+	defer s.hintsMu.Unlock()
+	if hint, ok := s.hints[req.Key]; !ok || req.Epoch >= hint.Epoch {
+		s.hints[req.Key] = req
+	}
+	return nil
+}
+
+// route handles the request if this node leads the key, the preferred leader electing itself if the key has no leader
+// yet, else forwards it once to the node most likely leading the key. Other replicas wait for their election timeout,
+// so a replica not yet having heard from the leader does not disrupt it.
+func route[Req any, Res any](s *server, key string, typ string, forwarded bool, fwd Req, lead func(p *partition) (Res, error)) (Res, error) {
+	if s.isReplica(key) {
+		p := s.partition(key)
+		p.mu.Lock()
+		leaderless := p.leader == ""
+		p.mu.Unlock()
+		if leaderless && p.replicas[0] == s.n.ID() {
+			s.elect(p)
+		}
+		if s.leads(p) {
+			return lead(p)
+		}
+	}
+	if forwarded {
+		return *new(Res), notLeaderError(key)
+	}
+
+	dest := s.leaderOf(key)
+	if dest == s.n.ID() {
+		return *new(Res), notLeaderError(key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	res, err := utils.SendWithContext[Req, Res](ctx, s.n, typ, dest, fwd)
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		s.suspect(key, dest)
+	}
+	return res, err
+}
+
+// leaderOf returns the known leader of the key, else its preferred leader.
+func (s *server) leaderOf(key string) nodeID {
+	if s.isReplica(key) {
+		p := s.partition(key)
+		p.mu.Lock()
+		leader := p.leader
+		p.mu.Unlock()
+		if leader != "" {
+			return leader
+		}
+	}
+	s.hintsMu.Lock()
+	defer s.hintsMu.Unlock()
+	if hint, ok := s.hints[key]; ok {
+		return hint.Leader
+	}
+	return s.replicas(key)[0]
+}
+
+// suspect moves the routing of the key on to the next replica after the unresponsive node, until a leader is announced.
+func (s *server) suspect(key string, node nodeID) {
+	replicas := s.replicas(key)
+	next := replicas[0]
+	for i, replica := range replicas {
+		if replica == node {
+			next = replicas[(i+1)%len(replicas)]
+		}
+	}
+	s.hintsMu.Lock()
+	defer s.hintsMu.Unlock()
+	hint := s.hints[key]
+	s.hints[key] = Leader{Key: key, Epoch: hint.Epoch, Leader: next}
+}
+
+func notLeaderError(key string) error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not the leader of "+key)
+}
+
+// forEachKey runs f for the keys in parallel and returns the first error, keeping its RPC error code.
+func forEachKey[V any](keys map[string]V, f func(key string, value V) error) error {
+	var mu sync.Mutex
+	var first error
+	var wg sync.WaitGroup
+	for key, value := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(key, value); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
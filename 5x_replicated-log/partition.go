@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	utils "github.com/tobiajo/gossip-gloomers/utils"
+)
+
+const (
+	replicationFactor = 3
+	heartbeatInterval = time.Second / 10
+	electionTimeout   = time.Second // randomized up to twice as long, so elections rarely collide
+	rpcTimeout        = time.Second / 2
+)
+
+type entry struct {
+	Epoch     int               `json:"epoch"`
+	Msg       *message          `json:"msg,omitempty"`     // nil for the no-op a new leader appends to commit the earlier epochs
+	Value     json.RawMessage   `json:"value,omitempty"`   // of a record, instead of Msg
+	Headers   map[string]string `json:"headers,omitempty"` // of a record
+	Timestamp int64             `json:"timestamp"`         // unix millis, assigned by the leader, never decreasing
+	Txn       string            `json:"txn,omitempty"`     // of a message or marker of a transaction
+	Primary   string            `json:"primary,omitempty"` // of a message of a transaction
+	Marker    string            `json:"marker,omitempty"`  // markerCommit or markerAbort, ending the transaction
+}
+
+// partition is the replica of the log of a key, led by one replica per epoch as in Raft. The leader appends and
+// replicates to the followers, an entry is committed once on a quorum of the replicas, and a replica only votes for a
+// candidate whose log is at least as complete as its own, so every committed entry survives a change of leader.
+// https://raft.github.io/raft.pdf
+type partition struct {
+	key            string
+	replicas       []nodeID
+	mu             sync.Mutex
+	epoch          int
+	leader         nodeID // of the epoch, "" if unknown
+	votedFor       nodeID // in the epoch
+	electing       bool
+	log            []entry
+	commit         int // the entries below are committed
+	consumerOffset int
+	heard          time.Time     // from the leader, or a granted vote
+	timeout        time.Duration // before electing
+	next           map[nodeID]int
+	match          map[nodeID]int
+	markers        map[string]int      // index of the marker ending each transaction in the log
+	open           map[string]*openTxn // transactions with messages but no marker in the log, tracked by the leader
+	scanned        int                 // the entries below are tracked in open
+}
+
+func newPartition(key string, replicas []nodeID) *partition {
+	return &partition{
+		key:      key,
+		replicas: replicas,
+		heard:    time.Now(),
+		timeout:  randomTimeout(),
+		next:     make(map[nodeID]int),
+		match:    make(map[nodeID]int),
+		markers:  make(map[string]int),
+		open:     make(map[string]*openTxn),
+	}
+}
+
+func randomTimeout() time.Duration {
+	return electionTimeout + time.Duration(rand.Int63n(int64(electionTimeout)))
+}
+
+// replicas returns the nodes following the position of the key on the ring, the first being its preferred leader.
+func (s *server) replicas(key string) []nodeID {
+	hash := fnv.New32()
+	hash.Write([]byte(key))
+	nodes := s.n.NodeIDs()
+	start := int(hash.Sum32() % uint32(len(nodes)))
+	replicas := []nodeID{}
+	for i := 0; i < min(replicationFactor, len(nodes)); i++ {
+		replicas = append(replicas, nodes[(start+i)%len(nodes)])
+	}
+	return replicas
+}
+
+func (s *server) isReplica(key string) bool {
+	for _, replica := range s.replicas(key) {
+		if replica == s.n.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) partition(key string) *partition {
+	s.partitions.SetIfAbsent(key, newPartition(key, s.replicas(key)))
+	p, _ := s.partitions.Get(key)
+	return p
+}
+
+func (p *partition) quorum() int {
+	return len(p.replicas)/2 + 1
+}
+
+func (p *partition) lastEpoch() int {
+	if len(p.log) == 0 {
+		return 0
+	}
+	return p.log[len(p.log)-1].Epoch
+}
+
+// now returns the timestamp of an entry appended by the leader, at least that of the last entry, caller must hold p.mu.
+func (p *partition) now() int64 {
+	now := time.Now().UnixMilli()
+	if len(p.log) > 0 {
+		now = max(now, p.log[len(p.log)-1].Timestamp)
+	}
+	return now
+}
+
+// append appends the entries to the log, indexing their markers, caller must hold p.mu.
+func (p *partition) append(entries ...entry) {
+	for _, e := range entries {
+		if e.Marker != "" {
+			p.markers[e.Txn] = len(p.log)
+		}
+		p.log = append(p.log, e)
+	}
+}
+
+// truncate drops the entries from the index on, caller must hold p.mu.
+func (p *partition) truncate(n int) {
+	for _, e := range p.log[n:] {
+		if e.Marker != "" {
+			delete(p.markers, e.Txn)
+		}
+	}
+	p.log = p.log[:n]
+}
+
+// observe steps down on seeing a later epoch, caller must hold p.mu.
+func (p *partition) observe(epoch int) {
+	if epoch > p.epoch {
+		p.epoch = epoch
+		p.leader = ""
+		p.votedFor = ""
+	}
+}
+
+// advanceCommit commits the entries of the epoch on a quorum, and so all before them, caller must hold p.mu.
+func (p *partition) advanceCommit(self nodeID) {
+	for n := len(p.log); n > p.commit && p.log[n-1].Epoch == p.epoch; n-- {
+		acks := 0
+		for _, replica := range p.replicas {
+			if replica == self || p.match[replica] >= n {
+				acks++
+			}
+		}
+		if acks >= p.quorum() {
+			p.commit = n
+			return
+		}
+	}
+}
+
+func (s *server) leads(p *partition) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leader == s.n.ID()
+}
+
+// tick heartbeats the partitions this node leads, resolves their transactions left open and elects a leader of those whose leader went silent.
+func (s *server) tick() {
+	for _, p := range s.partitions.Items() {
+		p.mu.Lock()
+		leading := p.leader == s.n.ID()
+		silent := !leading && time.Since(p.heard) > p.timeout
+		var expired map[string]string
+		if leading {
+			expired = p.expiredTxns()
+		}
+		p.mu.Unlock()
+		if leading {
+			go s.replicateAll(p)
+			for txn, primary := range expired {
+				go s.resolve(p, txn, primary)
+			}
+		} else if silent {
+			go s.elect(p)
+		}
+	}
+}
+
+func (s *server) elect(p *partition) {
+	p.mu.Lock()
+	if p.electing || p.leader == s.n.ID() {
+		p.mu.Unlock()
+		return
+	}
+	p.electing = true
+	p.epoch++
+	p.leader = ""
+	p.votedFor = s.n.ID()
+	p.heard = time.Now()
+	p.timeout = randomTimeout()
+	req := RequestVote{
+		Key:       p.key,
+		Epoch:     p.epoch,
+		Candidate: s.n.ID(),
+		LastEpoch: p.lastEpoch(),
+		Length:    len(p.log),
+	}
+	p.mu.Unlock()
+
+	var mu sync.Mutex
+	votes := 1
+	consumerOffset := 0
+	var wg sync.WaitGroup
+	for _, replica := range p.replicas {
+		if replica == s.n.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+			defer cancel()
+			res, err := utils.SendWithContext[RequestVote, RequestVoteOk](ctx, s.n, "request_vote", replica, req)
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.observe(res.Epoch)
+			p.mu.Unlock()
+			mu.Lock()
+			defer mu.Unlock()
+			if res.Granted {
+				votes++
+				consumerOffset = max(consumerOffset, res.ConsumerOffset)
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.electing = false
+	if p.epoch != req.Epoch || votes < p.quorum() {
+		p.mu.Unlock()
+		return
+	}
+	p.leader = s.n.ID()
+	for _, replica := range p.replicas {
+		p.next[replica] = len(p.log)
+		p.match[replica] = 0
+	}
+	p.consumerOffset = max(p.consumerOffset, consumerOffset) // a quorum of votes includes a replica of the last commit
+	p.open, p.scanned = make(map[string]*openTxn), 0         // the log may have changed while following
+	p.append(entry{Epoch: p.epoch, Timestamp: p.now()})
+	p.mu.Unlock()
+	log.Printf("leading %s in epoch %d", p.key, req.Epoch)
+
+	announce := Leader{Key: p.key, Epoch: req.Epoch, Leader: s.n.ID()}
+	for _, node := range s.n.NodeIDs() {
+		if node != s.n.ID() {
+			if err := utils.SendAsync(s.n, "leader", node, announce); err != nil {
+				log.Printf("error async announce to %s: %v", node, err)
+			}
+		}
+	}
+	s.replicateAll(p)
+}
+
+// replicateAll replicates to every follower and returns the number of replicas having the log as of the call.
+func (s *server) replicateAll(p *partition) int {
+	var mu sync.Mutex
+	acks := 1
+	var wg sync.WaitGroup
+	for _, replica := range p.replicas {
+		if replica == s.n.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.replicateTo(p, replica) {
+				mu.Lock()
+				acks++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return acks
+}
+
+// replicateTo brings the follower up to date with the log of the leader, stepping back on conflicting entries.
+func (s *server) replicateTo(p *partition, follower nodeID) bool {
+	for {
+		p.mu.Lock()
+		if p.leader != s.n.ID() {
+			p.mu.Unlock()
+			return false
+		}
+		prev := p.next[follower]
+		req := Replicate{
+			Key:            p.key,
+			Epoch:          p.epoch,
+			Leader:         s.n.ID(),
+			Prev:           prev,
+			Entries:        append([]entry{}, p.log[prev:]...),
+			Commit:         p.commit,
+			ConsumerOffset: p.consumerOffset,
+		}
+		if prev > 0 {
+			req.PrevEpoch = p.log[prev-1].Epoch
+		}
+		p.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+		res, err := utils.SendWithContext[Replicate, ReplicateOk](ctx, s.n, "replicate", follower, req)
+		cancel()
+		if err != nil {
+			return false
+		}
+
+		p.mu.Lock()
+		p.observe(res.Epoch)
+		if p.epoch != req.Epoch || p.leader != s.n.ID() {
+			p.mu.Unlock()
+			return false
+		}
+		if res.Success {
+			p.match[follower] = max(p.match[follower], res.Length)
+			p.next[follower] = p.match[follower]
+			p.advanceCommit(s.n.ID())
+			p.mu.Unlock()
+			return true
+		}
+		p.next[follower] = max(min(res.Length, prev-1), 0)
+		p.mu.Unlock()
+	}
+}
+
+func (s *server) replicateHandler(req Replicate) (ReplicateOk, error) {
+	p := s.partition(req.Key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observe(req.Epoch)
+	if req.Epoch < p.epoch {
+		return ReplicateOk{Epoch: p.epoch}, nil
+	}
+	p.leader = req.Leader
+	p.heard = time.Now()
+
+	if req.Prev > len(p.log) {
+		return ReplicateOk{Epoch: p.epoch, Length: len(p.log)}, nil
+	}
+	if req.Prev > 0 && p.log[req.Prev-1].Epoch != req.PrevEpoch {
+		return ReplicateOk{Epoch: p.epoch, Length: req.Prev - 1}, nil
+	}
+	for i, e := range req.Entries {
+		if req.Prev+i < len(p.log) && p.log[req.Prev+i].Epoch == e.Epoch {
+			continue // already appended, the request may be older than the log
+		}
+		p.truncate(req.Prev + i)
+		p.append(req.Entries[i:]...)
+		break
+	}
+	length := req.Prev + len(req.Entries)
+	p.commit = max(p.commit, min(req.Commit, length))
+	p.consumerOffset = req.ConsumerOffset
+
+	res := ReplicateOk{
+		Epoch:   p.epoch,
+		Success: true,
+		Length:  length,
+	}
+	return res, nil
+}
+
+func (s *server) requestVoteHandler(req RequestVote) (RequestVoteOk, error) {
+	p := s.partition(req.Key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observe(req.Epoch)
+	upToDate := req.LastEpoch > p.lastEpoch() || req.LastEpoch == p.lastEpoch() && req.Length >= len(p.log)
+	granted := req.Epoch == p.epoch && (p.votedFor == "" || p.votedFor == req.Candidate) && upToDate
+	if granted {
+		p.votedFor = req.Candidate
+		p.heard = time.Now()
+	}
+
+	res := RequestVoteOk{
+		Epoch:          p.epoch,
+		Granted:        granted,
+		ConsumerOffset: p.consumerOffset,
+	}
+	return res, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+func newTestServer(id string) *server {
+	n := maelstrom.NewNode()
+	n.Init(id, []string{"n0", "n1", "n2"})
+	return &server{
+		n:          n,
+		partitions: cmap.New[*partition](),
+		hints:      make(map[string]Leader),
+	}
+}
+
+func entries(epochs ...int) []entry {
+	log := []entry{}
+	for i, epoch := range epochs {
+		msg := i
+		log = append(log, entry{Epoch: epoch, Msg: &msg})
+	}
+	return log
+}
+
+func TestReplicateHandler(t *testing.T) {
+	s := newTestServer("n1")
+	p := s.partition("k")
+	p.epoch = 2
+	p.log = entries(1, 1, 2, 2) // the last two from a leader of epoch 2 never committed
+
+	res, err := s.replicateHandler(Replicate{Key: "k", Epoch: 1, Leader: "n0", Prev: 4, PrevEpoch: 2})
+	if err != nil || res.Success || res.Epoch != 2 {
+		t.Errorf("replicate of an earlier epoch = %+v, %v, want rejected in epoch 2", res, err)
+	}
+
+	res, _ = s.replicateHandler(Replicate{Key: "k", Epoch: 3, Leader: "n0", Prev: 3, PrevEpoch: 3})
+	if res.Success || res.Length != 2 {
+		t.Errorf("replicate after a conflicting entry = %+v, want rejected with length 2", res)
+	}
+
+	res, _ = s.replicateHandler(Replicate{Key: "k", Epoch: 3, Leader: "n0", Prev: 2, PrevEpoch: 1,
+		Entries: entries(3), Commit: 3, ConsumerOffset: 1})
+	if !res.Success || res.Length != 3 {
+		t.Errorf("replicate = %+v, want success with length 3", res)
+	}
+	if len(p.log) != 3 || p.log[2].Epoch != 3 || p.commit != 3 || p.leader != "n0" || p.consumerOffset != 1 {
+		t.Errorf("partition = %+v, want the conflicting entries replaced and committed", p)
+	}
+
+	res, _ = s.replicateHandler(Replicate{Key: "k", Epoch: 3, Leader: "n0", Prev: 0, Entries: entries(1)})
+	if !res.Success || len(p.log) != 3 {
+		t.Errorf("replicate of an older request = %+v, log of %d, want success keeping the log of 3", res, len(p.log))
+	}
+}
+
+func TestRequestVoteHandler(t *testing.T) {
+	s := newTestServer("n1")
+	p := s.partition("k")
+	p.epoch = 2
+	p.log = entries(1, 2)
+
+	tests := []struct {
+		name        string
+		req         RequestVote
+		wantGranted bool
+	}{
+		{
+			name:        "earlier epoch",
+			req:         RequestVote{Key: "k", Epoch: 1, Candidate: "n0", LastEpoch: 2, Length: 2},
+			wantGranted: false,
+		},
+		{
+			name:        "shorter log",
+			req:         RequestVote{Key: "k", Epoch: 3, Candidate: "n0", LastEpoch: 2, Length: 1},
+			wantGranted: false,
+		},
+		{
+			name:        "older last epoch",
+			req:         RequestVote{Key: "k", Epoch: 3, Candidate: "n0", LastEpoch: 1, Length: 5},
+			wantGranted: false,
+		},
+		{
+			name:        "as complete",
+			req:         RequestVote{Key: "k", Epoch: 3, Candidate: "n0", LastEpoch: 2, Length: 2},
+			wantGranted: true,
+		},
+		{
+			name:        "already voted",
+			req:         RequestVote{Key: "k", Epoch: 3, Candidate: "n2", LastEpoch: 3, Length: 3},
+			wantGranted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.requestVoteHandler(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Granted != tt.wantGranted {
+				t.Errorf("granted = %v, want %v", res.Granted, tt.wantGranted)
+			}
+		})
+	}
+}
+
+func TestAdvanceCommit(t *testing.T) {
+	p := newPartition("k", []nodeID{"n0", "n1", "n2"})
+	p.epoch = 2
+	p.leader = "n0"
+	p.log = entries(1, 1, 2)
+
+	p.match["n1"] = 2
+	p.advanceCommit("n0")
+	if p.commit != 0 {
+		t.Errorf("commit = %d, want 0 as only entries of earlier epochs are on a quorum", p.commit)
+	}
+
+	p.match["n2"] = 3
+	p.advanceCommit("n0")
+	if p.commit != 3 {
+		t.Errorf("commit = %d, want 3", p.commit)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// records returns the visible messages and records from the offset as records, caller must hold p.mu.
+func (p *partition) records(offset int, readCommitted bool) []Record {
+	records := []Record{}
+	for _, i := range p.visible(offset, readCommitted) {
+		e := p.log[i]
+		value := e.Value
+		if e.Msg != nil {
+			value = json.RawMessage(strconv.Itoa(*e.Msg))
+		}
+		records = append(records, Record{Offset: i, Value: value, Headers: e.Headers, Timestamp: e.Timestamp})
+	}
+	return records
+}
+
+// offsetForTime returns the offset of the first committed message or record with a timestamp at or after the given
+// one, else the end of the committed log, caller must hold p.mu. The timestamps never decrease along the log.
+func (p *partition) offsetForTime(timestamp int64) int {
+	i := sort.Search(p.commit, func(i int) bool { return p.log[i].Timestamp >= timestamp })
+	for i < p.commit && p.log[i].Msg == nil && p.log[i].Value == nil {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRecords(t *testing.T) {
+	p := newPartition("k", []nodeID{"n0", "n1", "n2"})
+	msg := 7
+	p.log = []entry{
+		{Epoch: 1, Timestamp: 100},
+		{Epoch: 1, Msg: &msg, Timestamp: 100},
+		{Epoch: 1, Value: json.RawMessage(`{"a":1}`), Headers: map[string]string{"h": "v"}, Timestamp: 200},
+		{Epoch: 1, Timestamp: 300},
+		{Epoch: 1, Msg: &msg, Timestamp: 400},
+	}
+	p.commit = len(p.log)
+
+	records := p.records(0, false)
+	if len(records) != 3 || string(records[0].Value) != "7" || string(records[1].Value) != `{"a":1}` ||
+		records[1].Headers["h"] != "v" || records[1].Offset != 2 || records[1].Timestamp != 200 {
+		t.Errorf("records = %+v, want the message, the record and the message", records)
+	}
+	if pairs := p.read(0, false); len(pairs) != 2 || pairs[1] != [2]int{4, 7} {
+		t.Errorf("read = %v, want only the messages", pairs)
+	}
+
+	tests := []struct {
+		timestamp int64
+		want      int
+	}{
+		{timestamp: 0, want: 1},
+		{timestamp: 150, want: 2},
+		{timestamp: 200, want: 2},
+		{timestamp: 250, want: 4}, // past the no-op
+		{timestamp: 500, want: 5},
+	}
+	for _, tt := range tests {
+		if got := p.offsetForTime(tt.timestamp); got != tt.want {
+			t.Errorf("offsetForTime(%d) = %d, want %d", tt.timestamp, got, tt.want)
+		}
+	}
+
+	p.commit = 3
+	if got := p.offsetForTime(250); got != 3 {
+		t.Errorf("offsetForTime(250) = %d, want the end of the committed log 3", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "encoding/json"
+
+type Send struct {
+	Key       string `json:"key"`
+	Msg       int    `json:"msg"`
+	Forwarded bool   `json:"forwarded,omitempty"` // by a node not leading the key
+}
+
+type SendOk struct {
+	Offset int `json:"offset"`
+}
+
+type SendBatch struct {
+	Msgs      []KeyedMsg `json:"msgs"`
+	Forwarded bool       `json:"forwarded,omitempty"`
+}
+
+type KeyedMsg struct {
+	Key string `json:"key"`
+	Msg int    `json:"msg"`
+}
+
+type SendBatchOk struct {
+	Offsets []*int            `json:"offsets"`          // per message, null if its key failed
+	Errors  map[string]string `json:"errors,omitempty"` // per failed key
+}
+
+type SendTxn struct {
+	Msgs []KeyedMsg `json:"msgs"`
+}
+
+type SendTxnOk struct {
+	Offsets []int `json:"offsets"`
+}
+
+// SendRecord sends a record with an arbitrary JSON value, timestamped by the leader of the key.
+type SendRecord struct {
+	Key       string            `json:"key"`
+	Value     json.RawMessage   `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Forwarded bool              `json:"forwarded,omitempty"`
+}
+
+type SendRecordOk struct {
+	Offset    int   `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type Poll struct {
+	Offsets       map[string]int `json:"offsets"`
+	ReadCommitted bool           `json:"read_committed,omitempty"` // hides the messages of aborted and open transactions
+	Forwarded     bool           `json:"forwarded,omitempty"`
+}
+
+type PollOk struct {
+	Msgs map[string][][2]int `json:"msgs"`
+}
+
+// PollRecords polls the messages and records of the keys as records, a message having its int as value.
+type PollRecords struct {
+	Offsets       map[string]int `json:"offsets"`
+	ReadCommitted bool           `json:"read_committed,omitempty"`
+	Forwarded     bool           `json:"forwarded,omitempty"`
+}
+
+type PollRecordsOk struct {
+	Records map[string][]Record `json:"records"`
+}
+
+type Record struct {
+	Offset    int               `json:"offset"`
+	Value     json.RawMessage   `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp int64             `json:"timestamp"` // unix millis
+}
+
+// OffsetForTime looks up the first offset per key with a timestamp at or after the given one, to poll from.
+type OffsetForTime struct {
+	Timestamps map[string]int64 `json:"timestamps"` // unix millis
+	Forwarded  bool             `json:"forwarded,omitempty"`
+}
+
+type OffsetForTimeOk struct {
+	Offsets map[string]int `json:"offsets"` // the end of the log if no later message
+}
+
+type CommitOffsets struct {
+	Offsets   map[string]int `json:"offsets"`
+	Forwarded bool           `json:"forwarded,omitempty"`
+}
+
+type CommitOffsetsOk struct{}
+
+type ListCommittedOffsets struct {
+	Keys      []string `json:"keys"`
+	Forwarded bool     `json:"forwarded,omitempty"`
+}
+
+type ListCommittedOffsetsOk struct {
+	Offsets map[string]int `json:"offsets"`
+}
@@ -0,0 +1,65 @@
+package main
+
+// Replicate appends the entries from Prev on, if the entry before matches PrevEpoch, also serving as heartbeat.
+type Replicate struct {
+	Key            string  `json:"key"`
+	Epoch          int     `json:"epoch"`
+	Leader         nodeID  `json:"leader"`
+	Prev           int     `json:"prev"`
+	PrevEpoch      int     `json:"prev_epoch"`
+	Entries        []entry `json:"entries"`
+	Commit         int     `json:"commit"`
+	ConsumerOffset int     `json:"consumer_offset"`
+}
+
+type ReplicateOk struct {
+	Epoch   int  `json:"epoch"`
+	Success bool `json:"success"`
+	Length  int  `json:"length"` // of the matching log, where to retry from if not successful
+}
+
+type RequestVote struct {
+	Key       string `json:"key"`
+	Epoch     int    `json:"epoch"`
+	Candidate nodeID `json:"candidate"`
+	LastEpoch int    `json:"last_epoch"`
+	Length    int    `json:"length"`
+}
+
+type RequestVoteOk struct {
+	Epoch          int  `json:"epoch"`
+	Granted        bool `json:"granted"`
+	ConsumerOffset int  `json:"consumer_offset"`
+}
+
+// Leader announces the leader of a key to every node, for routing.
+type Leader struct {
+	Key    string `json:"key"`
+	Epoch  int    `json:"epoch"`
+	Leader nodeID `json:"leader"`
+}
+
+// TxnAppend appends the messages of the transaction to the key, as not yet committed.
+type TxnAppend struct {
+	Key       string    `json:"key"`
+	Txn       string    `json:"txn"`
+	Primary   string    `json:"primary"` // the key deciding the outcome of the transaction
+	Msgs      []message `json:"msgs"`
+	Forwarded bool      `json:"forwarded,omitempty"`
+}
+
+type TxnAppendOk struct {
+	Offsets []int `json:"offsets"`
+}
+
+// TxnEnd appends the marker ending the transaction in the key, unless it already ended.
+type TxnEnd struct {
+	Key       string `json:"key"`
+	Txn       string `json:"txn"`
+	Commit    bool   `json:"commit"`
+	Forwarded bool   `json:"forwarded,omitempty"`
+}
+
+type TxnEndOk struct {
+	Committed bool `json:"committed"` // the outcome, which may differ from the request if ended before
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	markerCommit = "commit"
+	markerAbort  = "abort"
+	txnTimeout   = 5 * time.Second // before the leader of a key aborts a transaction left open in it
+)
+
+// A transaction appends its messages to each of its keys tagged with its id, then ends with a marker in each key, as
+// Kafka's transaction markers. The first key of the transaction is its primary, as in Percolator, the marker appended
+// first to the primary decides the outcome, so the coordinator and the leaders resolving a transaction of a failed
+// coordinator agree on it. A marker is only appended to the other keys after the primary has one, and no message of a
+// transaction is appended to a key after its marker.
+// https://www.confluent.io/blog/transactions-apache-kafka/
+// https://research.google/pubs/large-scale-incremental-processing-using-distributed-transactions-and-notifications/
+
+type openTxn struct {
+	primary   string
+	since     time.Time // first seen by the leader
+	resolving bool
+}
+
+// sendTxnHandler coordinates a transaction appending the messages to their keys, all committed or all aborted.
+func (s *server) sendTxnHandler(req SendTxn) (SendTxnOk, error) {
+	if len(req.Msgs) == 0 {
+		return SendTxnOk{Offsets: []int{}}, nil
+	}
+	txn := fmt.Sprintf("%s-%d", s.n.ID(), s.txns.Add(1))
+	primary := req.Msgs[0].Key
+	byKey := make(map[string][]int)
+	for i, msg := range req.Msgs {
+		byKey[msg.Key] = append(byKey[msg.Key], i)
+	}
+
+	var mu sync.Mutex
+	offsets := make([]int, len(req.Msgs))
+	var appendErr error
+	var wg sync.WaitGroup
+	for key, indices := range byKey {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fwd := TxnAppend{Key: key, Txn: txn, Primary: primary, Forwarded: true}
+			for _, j := range indices {
+				fwd.Msgs = append(fwd.Msgs, req.Msgs[j].Msg)
+			}
+			res, err := route(s, key, "txn_append", false, fwd, func(p *partition) (TxnAppendOk, error) {
+				return s.txnAppend(p, fwd)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if appendErr == nil {
+					appendErr = err
+				}
+				return
+			}
+			for i, j := range indices {
+				offsets[j] = res.Offsets[i]
+			}
+		}()
+	}
+	wg.Wait()
+
+	res, err := s.endTxn(primary, txn, appendErr == nil)
+	if err != nil {
+		return *new(SendTxnOk), err // the open transaction is resolved by the leaders of its keys
+	}
+	for key := range byKey {
+		if key == primary {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.endTxn(key, txn, res.Committed); err != nil {
+				log.Printf("error end %s in %s: %v", txn, key, err) // resolved by the leader of the key
+			}
+		}()
+	}
+	wg.Wait()
+	if !res.Committed {
+		if appendErr == nil {
+			appendErr = errors.New("aborted")
+		}
+		return *new(SendTxnOk), maelstrom.NewRPCError(maelstrom.TxnConflict, fmt.Sprintf("%s aborted: %v", txn, appendErr))
+	}
+
+	return SendTxnOk{Offsets: offsets}, nil
+}
+
+func (s *server) txnAppendHandler(req TxnAppend) (TxnAppendOk, error) {
+	return route(s, req.Key, "txn_append", req.Forwarded, req, func(p *partition) (TxnAppendOk, error) {
+		return s.txnAppend(p, req)
+	})
+}
+
+func (s *server) txnEndHandler(req TxnEnd) (TxnEndOk, error) {
+	return route(s, req.Key, "txn_end", req.Forwarded, req, func(p *partition) (TxnEndOk, error) {
+		return s.txnEnd(p, req.Txn, req.Commit)
+	})
+}
+
+// endTxn ends the transaction in the key, at its leader, returning the outcome.
+func (s *server) endTxn(key string, txn string, commit bool) (TxnEndOk, error) {
+	fwd := TxnEnd{Key: key, Txn: txn, Commit: commit, Forwarded: true}
+	return route(s, key, "txn_end", false, fwd, func(p *partition) (TxnEndOk, error) {
+		return s.txnEnd(p, txn, commit)
+	})
+}
+
+// txnAppend appends the messages of the transaction, unless it already ended in the key.
+func (s *server) txnAppend(p *partition, req TxnAppend) (TxnAppendOk, error) {
+	entries := make([]entry, len(req.Msgs))
+	for i, msg := range req.Msgs {
+		entries[i] = entry{Msg: &msg, Txn: req.Txn, Primary: req.Primary}
+	}
+	first, err := s.appendEntries(p, entries, func() error {
+		if _, ended := p.marker(req.Txn); ended {
+			return maelstrom.NewRPCError(maelstrom.TxnConflict, req.Txn+" already ended")
+		}
+		return nil
+	})
+	if err != nil {
+		return *new(TxnAppendOk), err
+	}
+	res := TxnAppendOk{
+		Offsets: make([]int, len(req.Msgs)),
+	}
+	for i := range req.Msgs {
+		res.Offsets[i] = first + i
+	}
+	return res, nil
+}
+
+// txnEnd appends the marker of the outcome to the key led by this node, unless the transaction already ended in it,
+// and returns the outcome once its marker is committed.
+func (s *server) txnEnd(p *partition, txn string, commit bool) (TxnEndOk, error) {
+	marker := markerAbort
+	if commit {
+		marker = markerCommit
+	}
+	p.mu.Lock()
+	if p.leader != s.n.ID() {
+		p.mu.Unlock()
+		return *new(TxnEndOk), notLeaderError(p.key)
+	}
+	i, ended := p.marker(txn)
+	if !ended {
+		i = len(p.log)
+		p.append(entry{Epoch: p.epoch, Timestamp: p.now(), Txn: txn, Marker: marker})
+	}
+	e := p.log[i]
+	p.mu.Unlock()
+
+	s.replicateAll(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.commit <= i || p.log[i].Epoch != e.Epoch { // else replaced by a later leader
+		return *new(TxnEndOk), maelstrom.NewRPCError(maelstrom.Timeout, "not replicated to a quorum")
+	}
+	return TxnEndOk{Committed: e.Marker == markerCommit}, nil
+}
+
+// resolve ends a transaction left open in the partition by its coordinator with the outcome of its primary, aborting
+// it there if not yet decided.
+func (s *server) resolve(p *partition, txn string, primary string) {
+	res, err := s.endTxn(primary, txn, false)
+	if err == nil {
+		_, err = s.txnEnd(p, txn, res.Committed)
+	}
+	if err != nil {
+		log.Printf("error resolve %s in %s: %v", txn, p.key, err)
+		p.mu.Lock()
+		if o, ok := p.open[txn]; ok {
+			o.resolving = false // retried on a later tick
+		}
+		p.mu.Unlock()
+	}
+}
+
+// marker returns the index of the marker of the transaction, if any, caller must hold p.mu.
+func (p *partition) marker(txn string) (int, bool) {
+	i, ok := p.markers[txn]
+	return i, ok
+}
+
+// expiredTxns tracks the transactions of the entries appended since the last call and returns those open for longer
+// than txnTimeout, not already being resolved, with their primaries. Caller must hold p.mu and lead the partition.
+func (p *partition) expiredTxns() map[string]string {
+	for ; p.scanned < len(p.log); p.scanned++ {
+		e := p.log[p.scanned]
+		if e.Marker != "" {
+			delete(p.open, e.Txn)
+		} else if _, ok := p.open[e.Txn]; e.Txn != "" && !ok {
+			p.open[e.Txn] = &openTxn{primary: e.Primary, since: time.Now()}
+		}
+	}
+	expired := make(map[string]string)
+	for txn, o := range p.open {
+		if !o.resolving && time.Since(o.since) > txnTimeout {
+			o.resolving = true
+			expired[txn] = o.primary
+		}
+	}
+	return expired
+}
+
+// visible returns the offsets of the committed messages and records from the offset. If readCommitted, those of
+// aborted transactions are skipped and it stops at the first of an open transaction, as later messages may be followed
+// by its commit. Caller must hold p.mu.
+func (p *partition) visible(offset int, readCommitted bool) []int {
+	var offsets []int
+	for i := max(offset, 0); i < p.commit; i++ {
+		e := p.log[i]
+		if e.Msg == nil && e.Value == nil {
+			continue
+		}
+		if readCommitted && e.Txn != "" {
+			marker, ended := p.marker(e.Txn)
+			if !ended || marker >= p.commit {
+				break
+			}
+			if p.log[marker].Marker == markerAbort {
+				continue
+			}
+		}
+		offsets = append(offsets, i)
+	}
+	return offsets
+}
+
+// read returns the visible messages from the offset, leaving out the records, caller must hold p.mu.
+func (p *partition) read(offset int, readCommitted bool) [][2]int {
+	var pairs [][2]int
+	for _, i := range p.visible(offset, readCommitted) {
+		if p.log[i].Msg != nil {
+			pairs = append(pairs, [2]int{i, *p.log[i].Msg})
+		}
+	}
+	return pairs
+}
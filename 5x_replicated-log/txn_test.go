@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func txnEntries(txn string, msgs ...int) []entry {
+	log := []entry{}
+	for _, msg := range msgs {
+		log = append(log, entry{Epoch: 1, Msg: &msg, Txn: txn, Primary: "k"})
+	}
+	return log
+}
+
+func TestRead(t *testing.T) {
+	p := newPartition("k", []nodeID{"n0", "n1", "n2"})
+	p.append(entries(1)...)                                    // 0
+	p.append(txnEntries("t1", 1)...)                           // 1
+	p.append(txnEntries("t2", 2)...)                           // 2
+	p.append(entry{Epoch: 1, Txn: "t2", Marker: markerAbort})  // 3
+	p.append(entry{Epoch: 1, Txn: "t1", Marker: markerCommit}) // 4
+	p.append(txnEntries("t3", 5)...)                           // 5
+	p.append(entries(1, 1, 1, 1, 1, 1, 1)[6:]...)              // 6
+	p.commit = len(p.log)
+
+	tests := []struct {
+		name          string
+		offset        int
+		readCommitted bool
+		want          [][2]int
+	}{
+		{
+			name:   "read uncommitted",
+			offset: 0,
+			want:   [][2]int{{0, 0}, {1, 1}, {2, 2}, {5, 5}, {6, 6}},
+		},
+		{
+			name:          "read committed",
+			offset:        0,
+			readCommitted: true,
+			want:          [][2]int{{0, 0}, {1, 1}},
+		},
+		{
+			name:          "read committed from an aborted message",
+			offset:        2,
+			readCommitted: true,
+			want:          nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.read(tt.offset, tt.readCommitted); !slices.Equal(got, tt.want) {
+				t.Errorf("read = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	p.commit = 4 // the commit marker of t1 not yet committed
+	if got := p.read(0, true); !slices.Equal(got, [][2]int{{0, 0}}) {
+		t.Errorf("read committed before the marker = %v, want only the message before t1", got)
+	}
+}
+
+func TestExpiredTxns(t *testing.T) {
+	p := newPartition("k", []nodeID{"n0", "n1", "n2"})
+	p.append(txnEntries("t1", 1, 2)...)
+	p.append(txnEntries("t2", 3)...)
+	p.append(entry{Epoch: 1, Txn: "t1", Marker: markerCommit})
+
+	if expired := p.expiredTxns(); len(expired) != 0 {
+		t.Errorf("expired = %v, want none yet", expired)
+	}
+	if len(p.open) != 1 || p.open["t2"] == nil {
+		t.Errorf("open = %v, want t2", p.open)
+	}
+
+	p.open["t2"].since = time.Now().Add(-2 * txnTimeout)
+	if expired := p.expiredTxns(); len(expired) != 1 || expired["t2"] != "k" {
+		t.Errorf("expired = %v, want t2 of primary k", expired)
+	}
+	if expired := p.expiredTxns(); len(expired) != 0 {
+		t.Errorf("expired = %v, want none while resolving", expired)
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	p := newPartition("k", []nodeID{"n0", "n1", "n2"})
+	p.append(txnEntries("t1", 1)...)
+	p.append(entry{Epoch: 1, Txn: "t1", Marker: markerAbort})
+	if i, ok := p.marker("t1"); !ok || i != 1 {
+		t.Errorf("marker(t1) = %d, %v, want 1", i, ok)
+	}
+
+	p.truncate(1) // replaced by a later leader
+	if _, ok := p.marker("t1"); ok {
+		t.Error("marker of a truncated entry still indexed")
+	}
+	p.append(entry{Epoch: 2}, entry{Epoch: 2, Txn: "t1", Marker: markerCommit})
+	if i, ok := p.marker("t1"); !ok || i != 2 {
+		t.Errorf("marker(t1) = %d, %v, want 2", i, ok)
+	}
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const GroupSessionTimeout = 3 * time.Second // members not heartbeating for this long are removed from their group
+
+// ConsumerGroup assigns the keys its members subscribe to, each key to one member. Every change of membership or
+// subscriptions rebalances the keys and bumps the generation, so commits of members lagging behind can be fenced.
+type ConsumerGroup struct {
+	Generation int                    `json:"generation"`
+	Members    map[string]GroupMember `json:"members"`
+	Assignment map[string]string      `json:"assignment"` // key to member
+}
+
+type GroupMember struct {
+	Keys    []string `json:"keys"`    // subscribed
+	Expires int64    `json:"expires"` // unix millis
+}
+
+func NewConsumerGroup() ConsumerGroup {
+	return ConsumerGroup{
+		Members:    make(map[string]GroupMember),
+		Assignment: make(map[string]string),
+	}
+}
+
+func (g ConsumerGroup) Clone() ConsumerGroup {
+	clone := ConsumerGroup{
+		Generation: g.Generation,
+		Members:    make(map[string]GroupMember, len(g.Members)),
+		Assignment: maps.Clone(g.Assignment),
+	}
+	for member, m := range g.Members {
+		clone.Members[member] = GroupMember{Keys: slices.Clone(m.Keys), Expires: m.Expires}
+	}
+	if clone.Assignment == nil {
+		clone.Assignment = make(map[string]string)
+	}
+	return clone
+}
+
+// Join adds the member subscribing to the keys, or updates the subscriptions of an existing member.
+func (g *ConsumerGroup) Join(member string, keys []string, now time.Time) {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	m, ok := g.Members[member]
+	g.Members[member] = GroupMember{Keys: keys, Expires: now.Add(GroupSessionTimeout).UnixMilli()}
+	if !ok || !slices.Equal(m.Keys, keys) {
+		g.rebalance()
+	}
+}
+
+// Heartbeat extends the session of the member, false if it is not a member, e.g. as its session expired.
+func (g *ConsumerGroup) Heartbeat(member string, now time.Time) bool {
+	m, ok := g.Members[member]
+	if !ok {
+		return false
+	}
+	m.Expires = now.Add(GroupSessionTimeout).UnixMilli()
+	g.Members[member] = m
+	return true
+}
+
+func (g *ConsumerGroup) Leave(member string) {
+	if _, ok := g.Members[member]; ok {
+		delete(g.Members, member)
+		g.rebalance()
+	}
+}
+
+// Expire removes the members whose session expired, true if any.
+func (g *ConsumerGroup) Expire(now time.Time) bool {
+	expired := false
+	for member, m := range g.Members {
+		if m.Expires <= now.UnixMilli() {
+			delete(g.Members, member)
+			expired = true
+		}
+	}
+	if expired {
+		g.rebalance()
+	}
+	return expired
+}
+
+// Keys returns the keys assigned to the member, sorted.
+func (g ConsumerGroup) Keys(member string) []string {
+	keys := []string{}
+	for key, owner := range g.Assignment {
+		if owner == member {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Owns tells if the key is assigned to the member in the generation.
+func (g ConsumerGroup) Owns(member string, generation int, key string) bool {
+	return g.Generation == generation && g.Assignment[key] == member
+}
+
+// rebalance assigns every subscribed key to the subscriber with the fewest keys so far, ties broken by name.
+func (g *ConsumerGroup) rebalance() {
+	g.Generation++
+	members := make([]string, 0, len(g.Members))
+	for member := range g.Members {
+		members = append(members, member)
+	}
+	slices.Sort(members)
+	subscribers := make(map[string][]string)
+	for _, member := range members {
+		for _, key := range g.Members[member].Keys {
+			subscribers[key] = append(subscribers[key], member)
+		}
+	}
+	keys := make([]string, 0, len(subscribers))
+	for key := range subscribers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	g.Assignment = make(map[string]string)
+	assigned := make(map[string]int)
+	for _, key := range keys {
+		owner := subscribers[key][0]
+		for _, member := range subscribers[key] {
+			if assigned[member] < assigned[owner] {
+				owner = member
+			}
+		}
+		g.Assignment[key] = owner
+		assigned[owner]++
+	}
+}
+
+func UnknownMemberError(group, member string) error {
+	return maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+		fmt.Sprintf("%s is not a member of group %q, rejoin", member, group))
+}
+
+func NotOwnerError(group, member string, generation int, key string) error {
+	return maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+		fmt.Sprintf("%s does not own %s in generation %d of group %q", member, key, generation, group))
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConsumerGroup_Rebalance(t *testing.T) {
+	now := time.Now()
+	g := NewConsumerGroup()
+
+	g.Join("c1", []string{"k1", "k2", "k3", "k4"}, now)
+	if got := g.Keys("c1"); !slices.Equal(got, []string{"k1", "k2", "k3", "k4"}) {
+		t.Errorf("Keys(c1) = %v, want all keys", got)
+	}
+	generation := g.Generation
+
+	g.Join("c2", []string{"k1", "k2", "k3", "k4"}, now)
+	if g.Generation == generation {
+		t.Error("join did not bump the generation")
+	}
+	if c1, c2 := g.Keys("c1"), g.Keys("c2"); len(c1) != 2 || len(c2) != 2 {
+		t.Errorf("Keys = %v and %v, want 2 each", c1, c2)
+	}
+
+	g.Join("c3", []string{"k5"}, now)
+	if got := g.Keys("c3"); !slices.Equal(got, []string{"k5"}) {
+		t.Errorf("Keys(c3) = %v, want [k5]", got)
+	}
+
+	g.Leave("c2")
+	if got := g.Keys("c1"); !slices.Equal(got, []string{"k1", "k2", "k3", "k4"}) {
+		t.Errorf("Keys(c1) after leave = %v, want all keys", got)
+	}
+}
+
+func TestConsumerGroup_Expire(t *testing.T) {
+	now := time.Now()
+	g := NewConsumerGroup()
+	g.Join("c1", []string{"k1"}, now)
+	g.Join("c2", []string{"k1"}, now.Add(GroupSessionTimeout/2))
+
+	if g.Expire(now.Add(GroupSessionTimeout / 2)) {
+		t.Error("expired a live member")
+	}
+	if !g.Heartbeat("c2", now.Add(GroupSessionTimeout)) {
+		t.Error("heartbeat of c2 failed")
+	}
+	if !g.Expire(now.Add(GroupSessionTimeout)) {
+		t.Error("did not expire c1")
+	}
+	if g.Heartbeat("c1", now.Add(GroupSessionTimeout)) {
+		t.Error("heartbeat of expired c1 succeeded")
+	}
+	if !g.Owns("c2", g.Generation, "k1") || g.Owns("c2", g.Generation-1, "k1") {
+		t.Error("c2 does not own k1 only in the current generation")
+	}
+}
+
+func TestConsumerGroup_Clone(t *testing.T) {
+	g := NewConsumerGroup()
+	g.Join("c1", []string{"k1"}, time.Now())
+	clone := g.Clone()
+	clone.Leave("c1")
+	if _, ok := g.Members["c1"]; !ok || g.Assignment["k1"] != "c1" {
+		t.Error("changing the clone changed the original")
+	}
+}
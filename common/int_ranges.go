@@ -0,0 +1,69 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// IntRanges is a set of ints serialized as sorted runs, where a run is either a single int or an inclusive [lo, hi]
+// pair, e.g. [1,2,3,7] as [[1,3],7]. A plain int array is valid input, so the encoding is backwards compatible.
+type IntRanges []int
+
+// MaxIntRangesLen bounds the ints a decoded IntRanges may expand to, so a single huge run cannot exhaust memory.
+const MaxIntRangesLen = 1 << 20
+
+func (r IntRanges) MarshalJSON() ([]byte, error) {
+	sorted := slices.Clone(r)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	runs := []any{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			runs = append(runs, sorted[i])
+		} else {
+			runs = append(runs, [2]int{sorted[i], sorted[j]})
+		}
+		i = j + 1
+	}
+	return json.Marshal(runs)
+}
+
+func (r *IntRanges) UnmarshalJSON(data []byte) error {
+	var runs []json.RawMessage
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return err
+	}
+
+	values := IntRanges{}
+	for _, run := range runs {
+		var single int
+		if err := json.Unmarshal(run, &single); err == nil {
+			if len(values) >= MaxIntRangesLen {
+				return fmt.Errorf("more than %d ints", MaxIntRangesLen)
+			}
+			values = append(values, single)
+			continue
+		}
+		var pair [2]int
+		if err := json.Unmarshal(run, &pair); err != nil {
+			return fmt.Errorf("invalid run %s: %w", run, err)
+		}
+		if pair[0] > pair[1] {
+			return fmt.Errorf("invalid run %s: lo greater than hi", run)
+		}
+		if n := uint64(pair[1]) - uint64(pair[0]) + 1; n == 0 || n > uint64(MaxIntRangesLen-len(values)) {
+			return fmt.Errorf("invalid run %s: more than %d ints", run, MaxIntRangesLen)
+		}
+		for i := 0; i <= pair[1]-pair[0]; i++ {
+			values = append(values, pair[0]+i)
+		}
+	}
+	*r = values
+	return nil
+}
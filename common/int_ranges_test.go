@@ -0,0 +1,141 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestIntRanges_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges IntRanges
+		want   string
+	}{
+		{
+			name:   "empty",
+			ranges: IntRanges{},
+			want:   `[]`,
+		},
+		{
+			name:   "single values",
+			ranges: IntRanges{5, 1},
+			want:   `[1,5]`,
+		},
+		{
+			name:   "runs",
+			ranges: IntRanges{7, 3, 2, 1, 9, 8},
+			want:   `[[1,3],[7,9]]`,
+		},
+		{
+			name:   "duplicates",
+			ranges: IntRanges{2, 1, 2, 4},
+			want:   `[[1,2],4]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.ranges)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntRanges_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    IntRanges
+		wantErr bool
+	}{
+		{
+			name:  "runs",
+			input: `[[1,3],7]`,
+			want:  IntRanges{1, 2, 3, 7},
+		},
+		{
+			name:  "plain int array",
+			input: `[4, 2]`,
+			want:  IntRanges{4, 2},
+		},
+		{
+			name:    "reversed run",
+			input:   `[[3,1]]`,
+			wantErr: true,
+		},
+		{
+			name:    "huge run",
+			input:   `[[0,9000000000000000000]]`,
+			wantErr: true,
+		},
+		{
+			name:    "full range run",
+			input:   `[[-9223372036854775808,9223372036854775807]]`,
+			wantErr: true,
+		},
+		{
+			name:    "runs adding up past the cap",
+			input:   fmt.Sprintf(`[[0,%d],[%d,%d]]`, MaxIntRangesLen-1, MaxIntRangesLen+1, MaxIntRangesLen+1),
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric run",
+			input:   `["1"]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got IntRanges
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("Unmarshal = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Acknowledging n messages, mostly consecutive as broadcast by the Maelstrom workload, with every 10th missing.
+func BenchmarkIntRanges(b *testing.B) {
+	messages := []int{}
+	for i := 0; i < 5000; i++ {
+		if i%10 != 0 {
+			messages = append(messages, i)
+		}
+	}
+
+	b.Run("plain", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(messages)
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "wire-bytes/op")
+	})
+
+	b.Run("ranges", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(IntRanges(messages))
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "wire-bytes/op")
+	})
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// PollLimits bounds the reply of a poll, over all keys and per key, 0 for no limit. The bytes are counted as the JSON
+// of the returned messages. At least one message is returned if any is available, even if exceeding MaxBytes, so a
+// consumer can always make progress.
+type PollLimits struct {
+	MaxMessages    int `json:"max_messages,omitempty"`
+	MaxBytes       int `json:"max_bytes,omitempty"`
+	KeyMaxMessages int `json:"key_max_messages,omitempty"`
+	KeyMaxBytes    int `json:"key_max_bytes,omitempty"`
+}
+
+// PollBudget tracks what is left of the limits while filling a reply key by key.
+type PollBudget struct {
+	limits   PollLimits
+	messages int
+	bytes    int
+}
+
+func (l PollLimits) Budget() *PollBudget {
+	return &PollBudget{limits: l}
+}
+
+// Limit returns how many messages the next key may return at most, math.MaxInt for no limit. Reading one more tells if
+// there is more data.
+func (b *PollBudget) Limit() int {
+	limit := math.MaxInt
+	if b.limits.KeyMaxMessages > 0 {
+		limit = b.limits.KeyMaxMessages
+	}
+	if b.limits.MaxMessages > 0 {
+		limit = min(limit, max(b.limits.MaxMessages-b.messages, 0))
+	}
+	return limit
+}
+
+// Take returns the prefix of the messages of a key within the budget and if any were left out.
+func Take[T any](b *PollBudget, msgs []T) ([]T, bool) {
+	limit := b.Limit()
+	keyBytes := 0
+	for i, msg := range msgs {
+		if i >= limit {
+			return msgs[:i], true
+		}
+		msgJson, err := json.Marshal(msg)
+		if err != nil {
+			return msgs[:i], true
+		}
+		size := len(msgJson) + 1    // separator
+		progress := b.messages == 0 // the first message of the reply is returned regardless of size
+		if !progress && (b.limits.MaxBytes > 0 && b.bytes+size > b.limits.MaxBytes ||
+			b.limits.KeyMaxBytes > 0 && keyBytes+size > b.limits.KeyMaxBytes) {
+			return msgs[:i], true
+		}
+		b.messages++
+		b.bytes += size
+		keyBytes += size
+	}
+	return msgs, false
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTake(t *testing.T) {
+	msgs := [][2]int{{0, 10}, {1, 11}, {2, 12}, {3, 13}} // 8 bytes each with the separator
+
+	tests := []struct {
+		name     string
+		limits   PollLimits
+		wantKey1 int
+		wantKey2 int
+	}{
+		{
+			name:     "no limits",
+			limits:   PollLimits{},
+			wantKey1: 4,
+			wantKey2: 4,
+		},
+		{
+			name:     "max messages",
+			limits:   PollLimits{MaxMessages: 6},
+			wantKey1: 4,
+			wantKey2: 2,
+		},
+		{
+			name:     "key max messages",
+			limits:   PollLimits{KeyMaxMessages: 3},
+			wantKey1: 3,
+			wantKey2: 3,
+		},
+		{
+			name:     "max bytes",
+			limits:   PollLimits{MaxBytes: 40},
+			wantKey1: 4,
+			wantKey2: 1,
+		},
+		{
+			name:     "key max bytes",
+			limits:   PollLimits{KeyMaxBytes: 20},
+			wantKey1: 2,
+			wantKey2: 2,
+		},
+		{
+			name:     "first message exceeding max bytes",
+			limits:   PollLimits{MaxBytes: 1},
+			wantKey1: 1,
+			wantKey2: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.limits.Budget()
+			key1, more1 := Take(b, msgs)
+			key2, more2 := Take(b, msgs)
+			if len(key1) != tt.wantKey1 || more1 != (tt.wantKey1 < len(msgs)) {
+				t.Errorf("key1 took %d, more %v, want %d", len(key1), more1, tt.wantKey1)
+			}
+			if len(key2) != tt.wantKey2 || more2 != (tt.wantKey2 < len(msgs)) {
+				t.Errorf("key2 took %d, more %v, want %d", len(key2), more2, tt.wantKey2)
+			}
+		})
+	}
+}
+
+func TestPollBudget_Limit(t *testing.T) {
+	if got := (PollLimits{}).Budget().Limit(); got != math.MaxInt {
+		t.Errorf("Limit without limits = %d, want math.MaxInt", got)
+	}
+	b := PollLimits{MaxMessages: 5, KeyMaxMessages: 3}.Budget()
+	Take(b, make([][2]int, 3))
+	if got := b.Limit(); got != 2 {
+		t.Errorf("Limit = %d, want 2", got)
+	}
+}
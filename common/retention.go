@@ -0,0 +1,57 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Retention decides which prefix of a log may be dropped, a message is dropped if any of the set limits drops it.
+// The zero value keeps every message.
+type Retention struct {
+	MaxMessages int           // keeps at most this many of the newest messages, 0 for no limit
+	Committed   bool          // drops the messages below the committed offset
+	MaxAge      time.Duration // drops the messages older than this, 0 for no limit
+}
+
+// Start returns the start of a log from start to end after retention, given the committed offset and the offset of
+// the oldest message younger than MaxAge.
+func (r Retention) Start(start, end, committed, unexpired int) int {
+	if r.MaxMessages > 0 {
+		start = max(start, end-r.MaxMessages)
+	}
+	if r.Committed {
+		start = max(start, committed)
+	}
+	if r.MaxAge > 0 {
+		start = max(start, unexpired)
+	}
+	return min(start, end)
+}
+
+// RetentionPolicy holds the retention of every key.
+type RetentionPolicy struct {
+	Default          Retention
+	Keys             map[string]Retention // overrides the default per key
+	RejectBelowStart bool                 // fails polls below the log start, rather than reading from the start
+}
+
+func (p RetentionPolicy) Of(key string) Retention {
+	if r, ok := p.Keys[key]; ok {
+		return r
+	}
+	return p.Default
+}
+
+// PollFrom returns the offset to poll the key from, given the requested offset and the log start.
+func (p RetentionPolicy) PollFrom(key string, offset, start int) (int, error) {
+	if offset >= start {
+		return offset, nil
+	}
+	if p.RejectBelowStart {
+		return *new(int), maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+			fmt.Sprintf("offset %d of %s is below the log start %d", offset, key, start))
+	}
+	return start, nil
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetention_Start(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention Retention
+		start     int
+		committed int
+		unexpired int
+		want      int
+	}{
+		{
+			name:      "keep all",
+			retention: Retention{},
+			committed: 50,
+			unexpired: 80,
+			want:      0,
+		},
+		{
+			name:      "max messages",
+			retention: Retention{MaxMessages: 30},
+			want:      70,
+		},
+		{
+			name:      "committed",
+			retention: Retention{Committed: true},
+			committed: 50,
+			want:      50,
+		},
+		{
+			name:      "committed past end",
+			retention: Retention{Committed: true},
+			committed: 120,
+			want:      100,
+		},
+		{
+			name:      "max age",
+			retention: Retention{MaxAge: time.Minute},
+			unexpired: 80,
+			want:      80,
+		},
+		{
+			name:      "any limit drops",
+			retention: Retention{MaxMessages: 30, Committed: true, MaxAge: time.Minute},
+			committed: 90,
+			unexpired: 80,
+			want:      90,
+		},
+		{
+			name:      "never moves back",
+			retention: Retention{MaxMessages: 30},
+			start:     85,
+			want:      85,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.retention.Start(tt.start, 100, tt.committed, tt.unexpired)
+			if got != tt.want {
+				t.Errorf("Start = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicy_PollFrom(t *testing.T) {
+	p := RetentionPolicy{}
+	if got, err := p.PollFrom("k", 3, 10); err != nil || got != 10 {
+		t.Errorf("PollFrom below start = %d, %v, want 10, nil", got, err)
+	}
+	if got, err := p.PollFrom("k", 12, 10); err != nil || got != 12 {
+		t.Errorf("PollFrom above start = %d, %v, want 12, nil", got, err)
+	}
+
+	p.RejectBelowStart = true
+	if _, err := p.PollFrom("k", 3, 10); err == nil {
+		t.Error("PollFrom below start succeeded, want error")
+	}
+}
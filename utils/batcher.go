@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher groups items per destination and flushes them in batches. A destination is flushed early once its batch
+// reaches MaxBatch items, otherwise when its oldest item has waited the current interval. The interval adapts between
+// MinInterval and LatencySLO: it stretches while flushed batches are small, i.e. light traffic where fewer and larger
+// batches save messages, and shrinks when batches fill up.
+type Batcher[T comparable] struct {
+	cfg      BatcherConfig
+	flush    func(dest string, items []T)
+	mu       sync.Mutex
+	pending  map[string]map[T]struct{}
+	oldest   map[string]time.Time
+	interval time.Duration
+}
+
+type BatcherConfig struct {
+	MaxBatch    int           // items per destination that trigger an early flush
+	MinInterval time.Duration // also the granularity of the flush loop
+	LatencySLO  time.Duration // upper bound on how long an item waits before flushing
+}
+
+func NewBatcher[T comparable](cfg BatcherConfig, flush func(dest string, items []T)) *Batcher[T] {
+	b := newBatcher(cfg, flush)
+	go func() {
+		for now := range time.Tick(cfg.MinInterval) {
+			b.flushExpired(now)
+		}
+	}()
+	return b
+}
+
+// newBatcher returns a batcher without its flush loop, flushed by calling flushExpired.
+func newBatcher[T comparable](cfg BatcherConfig, flush func(dest string, items []T)) *Batcher[T] {
+	return &Batcher[T]{
+		cfg:      cfg,
+		flush:    flush,
+		pending:  make(map[string]map[T]struct{}),
+		oldest:   make(map[string]time.Time),
+		interval: cfg.MinInterval,
+	}
+}
+
+// Add queues the item for the destination, an item already pending for it is batched once.
+func (b *Batcher[T]) Add(dest string, item T) {
+	b.mu.Lock()
+	batch, ok := b.pending[dest]
+	if !ok {
+		batch = make(map[T]struct{})
+		b.pending[dest] = batch
+		b.oldest[dest] = time.Now()
+	}
+	batch[item] = struct{}{}
+	var items []T
+	if len(batch) >= b.cfg.MaxBatch {
+		items = b.take(dest)
+		b.interval = max(b.interval/2, b.cfg.MinInterval)
+	}
+	b.mu.Unlock()
+
+	if items != nil {
+		b.flush(dest, items)
+	}
+}
+
+func (b *Batcher[T]) flushExpired(now time.Time) {
+	b.mu.Lock()
+	batches := make(map[string][]T)
+	for dest, oldest := range b.oldest {
+		if now.Sub(oldest) >= b.interval {
+			batches[dest] = b.take(dest)
+		}
+	}
+	for _, items := range batches {
+		if len(items) < b.cfg.MaxBatch/4 {
+			b.interval = min(b.interval*2, b.cfg.LatencySLO)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	for dest, items := range batches {
+		b.flush(dest, items)
+	}
+}
+
+// take removes the pending batch for the destination, caller must hold b.mu.
+func (b *Batcher[T]) take(dest string) []T {
+	items := make([]T, 0, len(b.pending[dest]))
+	for item := range b.pending[dest] {
+		items = append(items, item)
+	}
+	delete(b.pending, dest)
+	delete(b.oldest, dest)
+	return items
+}
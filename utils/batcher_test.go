@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var testBatcherConfig = BatcherConfig{
+	MaxBatch:    8,
+	MinInterval: 10 * time.Millisecond,
+	LatencySLO:  50 * time.Millisecond,
+}
+
+type flushed struct {
+	dest  string
+	items []int
+}
+
+func newTestBatcher() (*Batcher[int], *[]flushed) {
+	var flushes []flushed
+	b := newBatcher(testBatcherConfig, func(dest string, items []int) {
+		slices.Sort(items)
+		flushes = append(flushes, flushed{dest, items})
+	})
+	return b, &flushes
+}
+
+func TestBatcher_EarlyFlush(t *testing.T) {
+	b, flushes := newTestBatcher()
+	for i := 0; i < testBatcherConfig.MaxBatch-1; i++ {
+		b.Add("n1", i)
+		b.Add("n1", i) // batched once
+	}
+	b.Add("n2", 0)
+	if len(*flushes) != 0 {
+		t.Fatalf("flushed %v before a batch is full", *flushes)
+	}
+
+	b.Add("n1", testBatcherConfig.MaxBatch-1)
+	want := []flushed{{"n1", []int{0, 1, 2, 3, 4, 5, 6, 7}}}
+	if !slices.EqualFunc(*flushes, want, flushedEqual) {
+		t.Errorf("flushes = %v, want %v", *flushes, want)
+	}
+	if _, ok := b.pending["n2"]; !ok {
+		t.Error("other destination flushed early")
+	}
+}
+
+func TestBatcher_Interval(t *testing.T) {
+	b, flushes := newTestBatcher()
+	ms := time.Millisecond
+
+	// small batches stretch the interval up to the SLO
+	wantIntervals := []time.Duration{20 * ms, 40 * ms, 50 * ms, 50 * ms}
+	for i, want := range wantIntervals {
+		b.Add("n1", i)
+		b.flushExpired(time.Now().Add(b.interval))
+		if b.interval != want {
+			t.Errorf("interval after small batch %d = %v, want %v", i, b.interval, want)
+		}
+	}
+	if len(*flushes) != len(wantIntervals) {
+		t.Errorf("flushed %d batches, want %d", len(*flushes), len(wantIntervals))
+	}
+
+	// an item not yet waited the interval is kept
+	b.Add("n1", 100)
+	b.flushExpired(time.Now().Add(b.interval / 2))
+	if _, ok := b.pending["n1"]; !ok {
+		t.Error("item flushed before the interval")
+	}
+
+	// full batches shrink it down to the minimum
+	wantIntervals = []time.Duration{25 * ms, 12500 * time.Microsecond, 10 * ms, 10 * ms}
+	for i, want := range wantIntervals {
+		for j := 0; j < testBatcherConfig.MaxBatch; j++ {
+			b.Add("n1", 1000*(i+1)+j)
+		}
+		if b.interval != want {
+			t.Errorf("interval after full batch %d = %v, want %v", i, b.interval, want)
+		}
+	}
+}
+
+func flushedEqual(a, b flushed) bool {
+	return a.dest == b.dest && slices.Equal(a.items, b.items)
+}
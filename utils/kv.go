@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"context"
+)
+
+// KV is the subset of *maelstrom.KV used by the challenges, which lets tests substitute an in-memory store.
+type KV interface {
+	ReadInto(ctx context.Context, key string, v any) error
+	Write(ctx context.Context, key string, value any) error
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// MemKV is an in-memory linearizable KV for tests and benchmarks, counting the bytes that would go over the wire.
+type MemKV struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	bytes  int
+}
+
+func NewMemKV() *MemKV {
+	return &MemKV{values: make(map[string][]byte)}
+}
+
+func (kv *MemKV) ReadInto(ctx context.Context, key string, v any) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[key]
+	if !ok {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	kv.bytes += len(value)
+	return json.Unmarshal(value, v)
+}
+
+func (kv *MemKV) Write(ctx context.Context, key string, value any) error {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.values[key] = valueJson
+	kv.bytes += len(valueJson)
+	return nil
+}
+
+func (kv *MemKV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	fromJson, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	toJson, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.bytes += len(fromJson) + len(toJson)
+	value, ok := kv.values[key]
+	if !ok && !createIfNotExists {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	if ok && !bytes.Equal(value, fromJson) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "precondition failed")
+	}
+	kv.values[key] = toJson
+	return nil
+}
+
+// Bytes returns the number of value bytes transferred so far.
+func (kv *MemKV) Bytes() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.bytes
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -84,7 +85,12 @@ func RegisterAsyncHandler[Req any](n *maelstrom.Node, typ string, handler func(R
 	})
 }
 
-// Stubborn send, retries until success.
+var (
+	sendTimeout   = time.Second // per attempt of a stubborn send, as a lost request or reply never fails the RPC
+	retryInterval = time.Second // before retrying a stubborn send failed with an error reply
+)
+
+// Stubborn send, retries until success, each attempt timing out after sendTimeout.
 func Send[Req any, Res any](n *maelstrom.Node, typ string, dest string, req Req) (Res, error) {
 	reqJson, err := asJson(req)
 	if err != nil {
@@ -92,11 +98,39 @@ func Send[Req any, Res any](n *maelstrom.Node, typ string, dest string, req Req)
 	}
 	reqJson["type"] = typ
 
-	msg, err := n.SyncRPC(context.Background(), dest, reqJson)
+	msg, err := syncRPC(n, dest, reqJson)
 	for err != nil {
-		time.Sleep(time.Second)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			time.Sleep(retryInterval)
+		}
 		log.Printf("[typ=%s, dest=%s, req=%v] retrying send: %v", typ, dest, req, err)
-		msg, err = n.SyncRPC(context.Background(), dest, req)
+		msg, err = syncRPC(n, dest, reqJson)
+	}
+
+	var res Res
+	if err := json.Unmarshal(msg.Body, &res); err != nil {
+		return *new(Res), err
+	}
+	return res, nil
+}
+
+func syncRPC(n *maelstrom.Node, dest string, body any) (maelstrom.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return n.SyncRPC(ctx, dest, body)
+}
+
+// Send without retries, fails on timeout or cancellation of the context.
+func SendWithContext[Req any, Res any](ctx context.Context, n *maelstrom.Node, typ string, dest string, req Req) (Res, error) {
+	reqJson, err := asJson(req)
+	if err != nil {
+		return *new(Res), err
+	}
+	reqJson["type"] = typ
+
+	msg, err := n.SyncRPC(ctx, dest, reqJson)
+	if err != nil {
+		return *new(Res), err
 	}
 
 	var res Res
@@ -132,7 +166,7 @@ func asJson(v any) (map[string]any, error) {
 	return vJson, nil
 }
 
-func ReadOrElse[V any](kv *maelstrom.KV, key string, defaultValue V) (V, error) {
+func ReadOrElse[V any](kv KV, key string, defaultValue V) (V, error) {
 	var value V
 	err := kv.ReadInto(context.Background(), key, &value)
 	if err != nil {
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type echo struct {
+	Echo string `json:"echo"`
+}
+
+func TestSend_Retries(t *testing.T) {
+	sendTimeout, retryInterval = 10*time.Millisecond, time.Millisecond
+	defer func() { sendTimeout, retryInterval = time.Second, time.Second }()
+
+	n := maelstrom.NewNode()
+	in, network := io.Pipe()
+	out, node := io.Pipe()
+	n.Stdin, n.Stdout = in, node
+	n.Init("n0", []string{"n0", "n1"})
+	go n.Run()
+	defer network.Close()
+
+	// n1 drops the first request, fails the second and answers the third
+	var types []string
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for attempt := 0; scanner.Scan(); attempt++ {
+			var msg maelstrom.Message
+			var body map[string]any
+			if json.Unmarshal(scanner.Bytes(), &msg) != nil || json.Unmarshal(msg.Body, &body) != nil {
+				t.Errorf("malformed message %s", scanner.Bytes())
+				return
+			}
+			typ, _ := body["type"].(string)
+			types = append(types, typ)
+			reply := map[string]any{"in_reply_to": body["msg_id"], "type": "echo_ok", "echo": body["echo"]}
+			switch attempt {
+			case 0:
+				continue
+			case 1:
+				reply = map[string]any{"in_reply_to": body["msg_id"], "type": "error", "code": maelstrom.Crash}
+			}
+			replyJson, _ := json.Marshal(map[string]any{"src": "n1", "dest": "n0", "body": reply})
+			network.Write(append(replyJson, '\n'))
+		}
+	}()
+
+	res, err := Send[echo, echo](n, "echo", "n1", echo{Echo: "hi"})
+	if err != nil || res.Echo != "hi" {
+		t.Fatalf("Send = %v, %v, want the echo", res, err)
+	}
+	if len(types) != 3 || types[0] != "echo" || types[1] != "echo" || types[2] != "echo" {
+		t.Errorf("sent types %v, want 3 attempts of echo", types)
+	}
+}